@@ -17,9 +17,44 @@ type Config struct {
 	SMTPMaxRecipients     int           `env:"SMTP_MAX_RECIPIENTS"      envDefault:"50"`
 	SMTPAllowInsecureAuth bool          `env:"SMTP_ALLOW_INSECURE_AUTH" envDefault:"true"`
 
+	// SMTP TLS Configuration
+	SMTPStartTLS    bool     `env:"SMTP_STARTTLS"      envDefault:"false"` // Advertise STARTTLS
+	SMTPTLSCertFile string   `env:"SMTP_TLS_CERT_FILE"`                    // PEM certificate chain, generated when empty
+	SMTPTLSKeyFile  string   `env:"SMTP_TLS_KEY_FILE"`                     // PEM private key of SMTP_TLS_CERT_FILE
+	SMTPTLSHosts    []string `env:"SMTP_TLS_HOSTS"`                        // Extra names and IPs of the generated certificate
+	SMTPSAddr       string   `env:"SMTPS_ADDR"`                            // Implicit TLS listener, e.g. 127.0.0.1:10465, empty to disable
+
+	// SMTP TLS Policy, enforced on MAIL FROM
+	SMTPRequireTLS      bool   `env:"SMTP_REQUIRE_TLS"        envDefault:"false"` // Refuse mail before STARTTLS
+	SMTPTLSMinVersion   string `env:"SMTP_TLS_MIN_VERSION"`                       // 1.2 or 1.3, refuse mail over older TLS
+	SMTPTLSClientAuth   string `env:"SMTP_TLS_CLIENT_AUTH"    envDefault:"none"`  // none, request, verify or require
+	SMTPTLSClientCAFile string `env:"SMTP_TLS_CLIENT_CA_FILE"`                    // PEM CAs client certificates are verified against
+
+	// SMTP AUTH Configuration, AUTH is offered when credentials are configured or any are accepted
+	SMTPAuthUsers           []string `env:"SMTP_AUTH_USERS"`                                                      // user:password pairs
+	SMTPAuthUsersFile       string   `env:"SMTP_AUTH_USERS_FILE"`                                                 // File of user:password lines
+	SMTPAuthMechanisms      []string `env:"SMTP_AUTH_MECHANISMS"       envDefault:"PLAIN,LOGIN,CRAM-MD5,XOAUTH2"` // Offered mechanisms
+	SMTPAuthRequired        bool     `env:"SMTP_AUTH_REQUIRED"         envDefault:"false"`                        // Refuse mail before AUTH
+	SMTPAuthAcceptAny       bool     `env:"SMTP_AUTH_ACCEPT_ANY"       envDefault:"false"`                        // Accept every login and record it
+	SMTPAuthCapturePassword string   `env:"SMTP_AUTH_CAPTURE_PASSWORD" envDefault:"none"`                         // none, redacted or sha256
+
 	// HTTP Server Configuration
 	ViewAddr              string        `env:"VIEW_ADDR"                envDefault:"127.0.0.1:11080"`
 	ViewReadHeaderTimeout time.Duration `env:"VIEW_READ_HEADER_TIMEOUT" envDefault:"10s"`
+
+	// Retention Configuration (0 disables a limit)
+	RetentionMaxMessages   int           `env:"RETENTION_MAX_MESSAGES"   envDefault:"0"`
+	RetentionMaxBytes      int64         `env:"RETENTION_MAX_BYTES"      envDefault:"0"`
+	RetentionMaxAge        time.Duration `env:"RETENTION_MAX_AGE"        envDefault:"0s"`
+	RetentionSweepInterval time.Duration `env:"RETENTION_SWEEP_INTERVAL" envDefault:"1m"`
+
+	// Message Store Configuration
+	StoreType string `env:"STORE_TYPE" envDefault:"memory"`            // memory or bolt
+	StorePath string `env:"STORE_PATH" envDefault:"fakesmtpserver.db"` // Database file of the bolt store
+
+	// Maildir Sink Configuration
+	MaildirPath string `env:"MAILDIR_PATH"`                    // Maildir every message is also written to, empty to disable
+	MaildirLoad bool   `env:"MAILDIR_LOAD" envDefault:"false"` // Store the messages already in MAILDIR_PATH at startup
 }
 
 func LoadConfig() (*Config, error) {
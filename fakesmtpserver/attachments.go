@@ -0,0 +1,94 @@
+package fakesmtpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+)
+
+// smtpViewAttachment describes a non-body MIME part of a captured email.
+type smtpViewAttachment struct {
+	Index       int    `json:"index"`       // Position used by the download endpoint
+	Filename    string `json:"filename"`    // From Content-Disposition or Content-Type
+	ContentType string `json:"contentType"` // Without parameters
+	Size        int    `json:"size"`        // Decoded size in bytes
+	ContentID   string `json:"contentId"`   // For cid: references
+	Disposition string `json:"disposition"` // attachment, inline or empty
+	SHA256      string `json:"sha256"`      // Hex digest of the decoded content
+}
+
+// envelopeParts returns the attachments, inlines and other parts of e, in that order.
+// The position in the returned slice is the attachment index exposed over HTTP.
+func envelopeParts(e *enmime.Envelope) []*enmime.Part {
+	parts := make([]*enmime.Part, 0, len(e.Attachments)+len(e.Inlines)+len(e.OtherParts))
+	parts = append(parts, e.Attachments...)
+	parts = append(parts, e.Inlines...)
+	parts = append(parts, e.OtherParts...)
+
+	return parts
+}
+
+func newSMTPViewAttachments(e *enmime.Envelope) []*smtpViewAttachment {
+	parts := envelopeParts(e)
+	attachments := make([]*smtpViewAttachment, len(parts))
+	for i, p := range parts {
+		sum := sha256.Sum256(p.Content)
+		attachments[i] = &smtpViewAttachment{
+			Index:       i,
+			Filename:    p.FileName,
+			ContentType: p.ContentType,
+			Size:        len(p.Content),
+			ContentID:   p.ContentID,
+			Disposition: p.Disposition,
+			SHA256:      hex.EncodeToString(sum[:]),
+		}
+	}
+
+	return attachments
+}
+
+// partFinder selects one of parts, see envelopeParts, and returns its position.
+type partFinder func(parts []*enmime.Part) (int, bool)
+
+// partByIndex selects the part at index i.
+func partByIndex(i int) partFinder {
+	return func(parts []*enmime.Part) (int, bool) {
+		return i, i >= 0 && i < len(parts)
+	}
+}
+
+// partByFilename selects the first part with exactly the given filename.
+func partByFilename(name string) partFinder {
+	return func(parts []*enmime.Part) (int, bool) {
+		for i, p := range parts {
+			if p.FileName != "" && p.FileName == name {
+				return i, true
+			}
+		}
+
+		return 0, false
+	}
+}
+
+// GetAttachment returns the decoded content of the part of the message with the given ID that find selects.
+func (b *smtpBackend) GetAttachment(id string, find partFinder) (*smtpViewAttachment, []byte, bool) {
+	raw, ok := b.GetRawByID(id)
+	if !ok || raw == "" {
+		return nil, nil, false
+	}
+
+	e, err := enmime.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	parts := envelopeParts(e)
+	i, ok := find(parts)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return newSMTPViewAttachments(e)[i], parts[i].Content, true
+}
@@ -0,0 +1,451 @@
+package fakesmtpserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec // CRAM-MD5 is defined over HMAC-MD5
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+var (
+	// ErrInvalidAuthUser is returned when a configured credential is not a user:password pair.
+	ErrInvalidAuthUser = errors.New("invalid SMTP auth user: must be user:password")
+	// ErrUnknownAuthMechanism is returned when SMTP_AUTH_MECHANISMS names an unsupported mechanism.
+	ErrUnknownAuthMechanism = errors.New("unknown SMTP auth mechanism: must be PLAIN, LOGIN, CRAM-MD5 or XOAUTH2")
+	// ErrAuthRequiredWithoutUsers is returned when AUTH is required but no credentials are configured.
+	ErrAuthRequiredWithoutUsers = errors.New("SMTP_AUTH_REQUIRED requires SMTP_AUTH_USERS, SMTP_AUTH_USERS_FILE or SMTP_AUTH_ACCEPT_ANY")
+	// ErrInvalidPasswordCapture is returned when SMTP_AUTH_CAPTURE_PASSWORD is not a supported mode.
+	ErrInvalidPasswordCapture = errors.New("invalid SMTP_AUTH_CAPTURE_PASSWORD: must be none, redacted or sha256")
+	// ErrMissingAuthUser is returned when the user parameter is missing from an auth user search.
+	ErrMissingAuthUser = errors.New("missing required parameter: user")
+
+	// Reasons of failed logins.
+	errAuthUnknownUser      = errors.New("unknown user")
+	errAuthWrongPassword    = errors.New("wrong password")
+	errAuthIdentityMismatch = errors.New("authorization identity differs from user")
+	errAuthMalformed        = errors.New("malformed response")
+)
+
+const (
+	// SASL mechanisms selectable through SMTP_AUTH_MECHANISMS.
+	AuthPlain   = "PLAIN"
+	AuthLogin   = "LOGIN"
+	AuthCRAMMD5 = "CRAM-MD5"
+	AuthXOAUTH2 = "XOAUTH2"
+
+	// Password capture modes of SMTP_AUTH_CAPTURE_PASSWORD.
+	PasswordCaptureNone     = "none"
+	PasswordCaptureRedacted = "redacted" // First and last character, the rest masked
+	PasswordCaptureSHA256   = "sha256"   // Hex SHA-256 digest
+
+	// maxAuthFailures bounds the log of failed logins.
+	maxAuthFailures = 1000
+)
+
+type (
+	// smtpAuth is the AUTH configuration of the backend. AUTH is offered when it has users or accepts any.
+	smtpAuth struct {
+		users      map[string]string // Password, or XOAUTH2 token, by user
+		mechanisms []string
+		required   bool
+		hostname   string // Used in CRAM-MD5 challenges
+
+		// acceptAny lets every well-formed login succeed, recording the username and
+		// the password in the form chosen by capturePassword.
+		acceptAny       bool
+		capturePassword string
+	}
+
+	// authFailure is a failed login, see smtpBackend.AuthFailures.
+	authFailure struct {
+		Time         time.Time `json:"time"`
+		ConnectionID uint64    `json:"connectionId"`
+		Listener     string    `json:"listener"`
+		ClientAddr   string    `json:"clientAddr"`
+		Mechanism    string    `json:"mechanism"`
+		Username     string    `json:"username"`
+		Reason       string    `json:"reason"`
+	}
+)
+
+// ConfigureAuth sets up AUTH on the shared backend from the credentials in cfg.
+// It must be called before the SMTP servers are started.
+func ConfigureAuth(cfg *config.Config) error {
+	auth, err := newSMTPAuth(cfg)
+	if err != nil {
+		return err
+	}
+	sharedBackend.auth = auth
+
+	return nil
+}
+
+func newSMTPAuth(cfg *config.Config) (smtpAuth, error) {
+	lines := slices.Clone(cfg.SMTPAuthUsers)
+	if cfg.SMTPAuthUsersFile != "" {
+		data, err := os.ReadFile(cfg.SMTPAuthUsersFile)
+		if err != nil {
+			return smtpAuth{}, fmt.Errorf("read auth users: %w", err)
+		}
+		for line := range strings.Lines(string(data)) {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	a := smtpAuth{
+		users:           make(map[string]string, len(lines)),
+		required:        cfg.SMTPAuthRequired,
+		hostname:        cfg.SMTPHostname,
+		acceptAny:       cfg.SMTPAuthAcceptAny,
+		capturePassword: cfg.SMTPAuthCapturePassword,
+	}
+	for i, line := range lines {
+		user, password, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			// The entry may hold a password, so only its position is reported.
+			return smtpAuth{}, fmt.Errorf("%w: entry %d", ErrInvalidAuthUser, i+1)
+		}
+		a.users[user] = password
+	}
+
+	for _, mech := range cfg.SMTPAuthMechanisms {
+		mech = strings.ToUpper(strings.TrimSpace(mech))
+		switch mech {
+		case AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAUTH2:
+			if !slices.Contains(a.mechanisms, mech) {
+				a.mechanisms = append(a.mechanisms, mech)
+			}
+		default:
+			return smtpAuth{}, fmt.Errorf("%w: %s", ErrUnknownAuthMechanism, mech)
+		}
+	}
+
+	switch a.capturePassword {
+	case "":
+		a.capturePassword = PasswordCaptureNone
+	case PasswordCaptureNone, PasswordCaptureRedacted, PasswordCaptureSHA256:
+	default:
+		return smtpAuth{}, fmt.Errorf("%w: %s", ErrInvalidPasswordCapture, a.capturePassword)
+	}
+
+	if a.required && !a.enabled() {
+		return smtpAuth{}, ErrAuthRequiredWithoutUsers
+	}
+
+	return a, nil
+}
+
+func (a smtpAuth) enabled() bool {
+	return (len(a.users) > 0 || a.acceptAny) && len(a.mechanisms) > 0
+}
+
+// checkPassword verifies the password, or XOAUTH2 token, of user.
+func (a smtpAuth) checkPassword(user, password string) error {
+	if a.acceptAny {
+		return nil
+	}
+
+	want, ok := a.users[user]
+	if !ok {
+		return errAuthUnknownUser
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 {
+		return errAuthWrongPassword
+	}
+
+	return nil
+}
+
+// checkCRAMMD5 verifies the hex HMAC-MD5 digest of challenge sent by user.
+func (a smtpAuth) checkCRAMMD5(user string, challenge []byte, digest string) error {
+	if a.acceptAny {
+		return nil
+	}
+
+	password, ok := a.users[user]
+	if !ok {
+		return errAuthUnknownUser
+	}
+
+	mac := hmac.New(md5.New, []byte(password))
+	mac.Write(challenge)
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.ToLower(digest))) {
+		return errAuthWrongPassword
+	}
+
+	return nil
+}
+
+// capturedPassword returns password in the form recorded on messages, empty when passwords are not
+// captured or none was sent.
+func (a smtpAuth) capturedPassword(password string) string {
+	if password == "" {
+		return ""
+	}
+
+	switch a.capturePassword {
+	case PasswordCaptureRedacted:
+		return redactPassword(password)
+	case PasswordCaptureSHA256:
+		sum := sha256.Sum256([]byte(password))
+
+		return hex.EncodeToString(sum[:])
+	default:
+		return ""
+	}
+}
+
+// redactPassword masks all but the first and last character of password. Passwords
+// too short to keep anything hidden are masked completely.
+func redactPassword(password string) string {
+	r := []rune(password)
+	if len(r) < 6 {
+		return strings.Repeat("*", len(r))
+	}
+
+	return string(r[0]) + strings.Repeat("*", len(r)-2) + string(r[len(r)-1])
+}
+
+// newCRAMMD5Challenge returns a unique challenge in the msg-id form of RFC 2195.
+func (a smtpAuth) newCRAMMD5Challenge() ([]byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("generate challenge: %w", err)
+	}
+
+	return fmt.Appendf(nil, "<%d.%d@%s>", n, time.Now().Unix(), a.hostname), nil
+}
+
+// AuthMechanisms returns the mechanisms advertised in EHLO, none when AUTH is not configured.
+func (s *smtpSession) AuthMechanisms() []string {
+	if !s.backend.auth.enabled() {
+		return nil
+	}
+
+	return s.backend.auth.mechanisms
+}
+
+// Auth starts an AUTH exchange using mech.
+func (s *smtpSession) Auth(mech string) (sasl.Server, error) {
+	auth := s.backend.auth
+	if !auth.enabled() || !slices.Contains(auth.mechanisms, mech) {
+		return nil, smtp.ErrAuthUnknownMechanism
+	}
+
+	switch mech {
+	case AuthPlain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			if identity != "" && identity != username && !auth.acceptAny {
+				return s.finishAuth(mech, username, "", errAuthIdentityMismatch)
+			}
+
+			return s.finishAuth(mech, username, password, auth.checkPassword(username, password))
+		}), nil
+	case AuthLogin:
+		return &loginServer{authenticate: func(username, password string) error {
+			return s.finishAuth(mech, username, password, auth.checkPassword(username, password))
+		}}, nil
+	case AuthCRAMMD5:
+		challenge, err := auth.newCRAMMD5Challenge()
+		if err != nil {
+			return nil, err
+		}
+
+		return &cramMD5Server{challenge: challenge, authenticate: func(username, digest string) error {
+			if digest == "" {
+				return s.finishAuth(mech, username, "", errAuthMalformed)
+			}
+
+			// The password is not sent, so there is nothing to capture.
+			return s.finishAuth(mech, username, "", auth.checkCRAMMD5(username, challenge, digest))
+		}}, nil
+	default:
+		return &xoauth2Server{authenticate: func(username, token string) error {
+			if username == "" || token == "" {
+				return s.finishAuth(mech, username, "", errAuthMalformed)
+			}
+
+			return s.finishAuth(mech, username, token, auth.checkPassword(username, token))
+		}}, nil
+	}
+}
+
+// finishAuth ends an AUTH exchange for username, which sent password, with the outcome err.
+// On success the connection is marked as authenticated; on failure the attempt is logged.
+func (s *smtpSession) finishAuth(mech, username, password string, err error) error {
+	if err != nil {
+		s.backend.recordAuthFailure(authFailure{
+			Time:         time.Now(),
+			ConnectionID: s.conn.id,
+			Listener:     s.conn.listener,
+			ClientAddr:   s.conn.clientAddr,
+			Mechanism:    mech,
+			Username:     username,
+			Reason:       err.Error(),
+		})
+
+		return smtp.ErrAuthFailed
+	}
+
+	// Messages already received on this connection keep sharing the old connection.
+	conn := *s.conn
+	conn.authenticated = true
+	conn.authMechanism = mech
+	conn.authUser = username
+	conn.authPassword = s.backend.auth.capturedPassword(password)
+	s.conn = &conn
+	s.msg.conn = &conn
+
+	return nil
+}
+
+// authRequired returns the SMTP error refusing mail before AUTH, or nil.
+func (s *smtpSession) authRequired() error {
+	if !s.backend.auth.required || s.conn.authenticated {
+		return nil
+	}
+
+	return &smtp.SMTPError{
+		Code:         530,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+		Message:      "Authentication required",
+	}
+}
+
+func (b *smtpBackend) recordAuthFailure(f authFailure) {
+	slog.Info("AUTH failed", "mechanism", f.Mechanism, "username", f.Username, "reason", f.Reason, "clientAddr", f.ClientAddr)
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.authFailures = append(b.authFailures, f)
+	if over := len(b.authFailures) - maxAuthFailures; over > 0 {
+		b.authFailures = append(b.authFailures[:0:0], b.authFailures[over:]...)
+	}
+}
+
+// AuthFailures returns the most recent failed logins, oldest first.
+func (b *smtpBackend) AuthFailures() []authFailure {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	return append([]authFailure{}, b.authFailures...)
+}
+
+// ClearAuthFailures empties the log of failed logins and returns how many were removed.
+func (b *smtpBackend) ClearAuthFailures() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	n := len(b.authFailures)
+	b.authFailures = nil
+
+	return n
+}
+
+// loginServer implements the LOGIN mechanism, which sends the username and password
+// in answer to two prompts. A username sent as initial response skips the first prompt.
+type loginServer struct {
+	step         int // 0 at the start, 1 after the username prompt, 2 after the password prompt
+	username     string
+	authenticate func(username, password string) error
+}
+
+func (l *loginServer) Next(response []byte) ([]byte, bool, error) {
+	if l.step == 0 && response == nil {
+		l.step = 1
+
+		return []byte("Username:"), false, nil
+	}
+
+	if l.step <= 1 {
+		l.username = string(response)
+		l.step = 2
+
+		return []byte("Password:"), false, nil
+	}
+
+	return nil, true, l.authenticate(l.username, string(response))
+}
+
+// cramMD5Server implements the CRAM-MD5 mechanism of RFC 2195.
+type cramMD5Server struct {
+	challenge    []byte
+	sent         bool
+	authenticate func(username, digest string) error
+}
+
+func (c *cramMD5Server) Next(response []byte) ([]byte, bool, error) {
+	if !c.sent {
+		if response != nil {
+			return nil, false, sasl.ErrUnexpectedClientResponse
+		}
+		c.sent = true
+
+		return c.challenge, false, nil
+	}
+
+	username, digest, ok := strings.Cut(string(response), " ")
+	if !ok {
+		digest = ""
+	}
+
+	return nil, true, c.authenticate(username, digest)
+}
+
+// xoauth2Server implements Google's XOAUTH2 mechanism. A rejected token is answered with an
+// error challenge, which the client acknowledges with an empty response before AUTH fails.
+type xoauth2Server struct {
+	failed       bool
+	authenticate func(username, token string) error
+}
+
+// xoauth2ErrorChallenge is the error challenge sent for a rejected token.
+const xoauth2ErrorChallenge = `{"status":"401","schemes":"bearer"}`
+
+func (x *xoauth2Server) Next(response []byte) ([]byte, bool, error) {
+	switch {
+	case x.failed:
+		return nil, false, smtp.ErrAuthFailed
+	case response == nil:
+		return []byte{}, false, nil
+	}
+
+	var username, token string
+	for field := range bytes.SplitSeq(response, []byte{1}) {
+		if v, ok := bytes.CutPrefix(field, []byte("user=")); ok {
+			username = string(v)
+		} else if v, ok := bytes.CutPrefix(field, []byte("auth=")); ok {
+			scheme, t, _ := strings.Cut(string(v), " ")
+			if strings.EqualFold(scheme, "Bearer") {
+				token = t
+			}
+		}
+	}
+
+	if err := x.authenticate(username, token); err != nil {
+		x.failed = true
+
+		return []byte(xoauth2ErrorChallenge), false, nil //nolint:nilerr // the failure is reported after the client acknowledges
+	}
+
+	return nil, true, nil
+}
@@ -0,0 +1,396 @@
+package fakesmtpserver
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+// cramMD5Client is a CRAM-MD5 client, which go-sasl does not provide.
+type cramMD5Client struct{ username, password string }
+
+func (c *cramMD5Client) Start() (string, []byte, error) { return AuthCRAMMD5, nil, nil }
+
+func (c *cramMD5Client) Next(challenge []byte) ([]byte, error) {
+	mac := hmac.New(md5.New, []byte(c.password))
+	mac.Write(challenge)
+
+	return []byte(c.username + " " + hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// xoauth2Client is an XOAUTH2 client, which go-sasl does not provide.
+type xoauth2Client struct{ username, token string }
+
+func (c *xoauth2Client) Start() (string, []byte, error) {
+	return AuthXOAUTH2, []byte("user=" + c.username + "\x01auth=Bearer " + c.token + "\x01\x01"), nil
+}
+
+func (c *xoauth2Client) Next([]byte) ([]byte, error) { return []byte{}, nil }
+
+func testAuthConfig() *config.Config {
+	return &config.Config{
+		SMTPHostname:       "mail.example.test",
+		SMTPAuthUsers:      []string{"alice:secret", "svc-billing:token-123"},
+		SMTPAuthMechanisms: []string{AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAUTH2},
+	}
+}
+
+func TestNewSMTPAuth(t *testing.T) {
+	usersFile := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(usersFile, []byte("# service accounts\nbob:pa:ss\n\n  carol:x  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		modify     func(cfg *config.Config)
+		wantUsers  map[string]string
+		wantMechs  []string
+		wantErr    error
+		wantSecret string // Must not appear in the error
+	}{
+		{
+			name:      "env",
+			modify:    func(*config.Config) {},
+			wantUsers: map[string]string{"alice": "secret", "svc-billing": "token-123"},
+			wantMechs: []string{AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAUTH2},
+		},
+		{
+			name: "file_and_mechanisms",
+			modify: func(cfg *config.Config) {
+				cfg.SMTPAuthUsers = []string{"alice:secret"}
+				cfg.SMTPAuthUsersFile = usersFile
+				cfg.SMTPAuthMechanisms = []string{"cram-md5", " plain", "PLAIN"}
+			},
+			wantUsers: map[string]string{"alice": "secret", "bob": "pa:ss", "carol": "x"},
+			wantMechs: []string{AuthCRAMMD5, AuthPlain},
+		},
+		{
+			name:      "disabled",
+			modify:    func(cfg *config.Config) { cfg.SMTPAuthUsers = nil },
+			wantUsers: map[string]string{},
+			wantMechs: []string{AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAUTH2},
+		},
+		{
+			name:       "invalid_user",
+			modify:     func(cfg *config.Config) { cfg.SMTPAuthUsers = []string{"no-colon-secret"} },
+			wantErr:    ErrInvalidAuthUser,
+			wantSecret: "no-colon-secret",
+		},
+		{
+			name:    "unknown_mechanism",
+			modify:  func(cfg *config.Config) { cfg.SMTPAuthMechanisms = []string{"DIGEST-MD5"} },
+			wantErr: ErrUnknownAuthMechanism,
+		},
+		{
+			name: "required_without_users",
+			modify: func(cfg *config.Config) {
+				cfg.SMTPAuthUsers = nil
+				cfg.SMTPAuthRequired = true
+			},
+			wantErr: ErrAuthRequiredWithoutUsers,
+		},
+		{
+			name: "required_accepting_any",
+			modify: func(cfg *config.Config) {
+				cfg.SMTPAuthUsers = nil
+				cfg.SMTPAuthRequired = true
+				cfg.SMTPAuthAcceptAny = true
+			},
+			wantUsers: map[string]string{},
+			wantMechs: []string{AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAUTH2},
+		},
+		{
+			name:    "invalid_password_capture",
+			modify:  func(cfg *config.Config) { cfg.SMTPAuthCapturePassword = "plain" },
+			wantErr: ErrInvalidPasswordCapture,
+		},
+		{
+			name:    "missing_file",
+			modify:  func(cfg *config.Config) { cfg.SMTPAuthUsersFile = filepath.Join(t.TempDir(), "missing") },
+			wantErr: os.ErrNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testAuthConfig()
+			tt.modify(cfg)
+
+			auth, err := newSMTPAuth(cfg)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("newSMTPAuth() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantSecret != "" && strings.Contains(err.Error(), tt.wantSecret) {
+					t.Errorf("newSMTPAuth() error %q reveals the credential", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("newSMTPAuth() error = %v", err)
+			}
+
+			if len(auth.users) != len(tt.wantUsers) {
+				t.Errorf("users = %v, want %v", auth.users, tt.wantUsers)
+			}
+			for user, password := range tt.wantUsers {
+				if auth.users[user] != password {
+					t.Errorf("users[%s] = %q, want %q", user, auth.users[user], password)
+				}
+			}
+			if !slices.Equal(auth.mechanisms, tt.wantMechs) {
+				t.Errorf("mechanisms = %v, want %v", auth.mechanisms, tt.wantMechs)
+			}
+		})
+	}
+}
+
+func TestLoginServerPrompts(t *testing.T) {
+	var gotUser, gotPassword string
+	l := &loginServer{authenticate: func(username, password string) error {
+		gotUser, gotPassword = username, password
+
+		return nil
+	}}
+
+	steps := []struct {
+		response      []byte
+		wantChallenge string
+		wantDone      bool
+	}{
+		{nil, "Username:", false},
+		{[]byte("alice"), "Password:", false},
+		{[]byte("secret"), "", true},
+	}
+	for i, step := range steps {
+		challenge, done, err := l.Next(step.response)
+		if err != nil || string(challenge) != step.wantChallenge || done != step.wantDone {
+			t.Fatalf("step %d: Next() = %q, %v, %v, want %q, %v", i, challenge, done, err, step.wantChallenge, step.wantDone)
+		}
+	}
+	if gotUser != "alice" || gotPassword != "secret" {
+		t.Errorf("authenticated %q/%q, want alice/secret", gotUser, gotPassword)
+	}
+}
+
+func TestSMTPAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     sasl.Client
+		wantUser   string
+		wantReason string
+	}{
+		{"plain", sasl.NewPlainClient("", "alice", "secret"), "alice", ""},
+		{"plain_wrong_password", sasl.NewPlainClient("", "alice", "wrong"), "", errAuthWrongPassword.Error()},
+		{"plain_unknown_user", sasl.NewPlainClient("", "mallory", "secret"), "", errAuthUnknownUser.Error()},
+		{"plain_identity_mismatch", sasl.NewPlainClient("svc-billing", "alice", "secret"), "", errAuthIdentityMismatch.Error()},
+		{"login", sasl.NewLoginClient("alice", "secret"), "alice", ""},
+		{"login_wrong_password", sasl.NewLoginClient("alice", "wrong"), "", errAuthWrongPassword.Error()},
+		{"cram_md5", &cramMD5Client{"alice", "secret"}, "alice", ""},
+		{"cram_md5_wrong_password", &cramMD5Client{"alice", "wrong"}, "", errAuthWrongPassword.Error()},
+		{"xoauth2", &xoauth2Client{"svc-billing", "token-123"}, "svc-billing", ""},
+		{"xoauth2_wrong_token", &xoauth2Client{"svc-billing", "expired"}, "", errAuthWrongPassword.Error()},
+		{"xoauth2_malformed", &xoauth2Client{"", "token-123"}, "", errAuthMalformed.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testAuthConfig()
+			cfg.SMTPAuthRequired = true
+			backend, addr := startTestAuthServer(t, cfg)
+
+			c, err := gosmtp.Dial(addr)
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer c.Close()
+
+			err = c.Auth(tt.client)
+			if tt.wantReason != "" {
+				var smtpErr *gosmtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != 535 {
+					t.Fatalf("Auth() error = %v, want 535", err)
+				}
+				failures := backend.AuthFailures()
+				if len(failures) != 1 || failures[0].Reason != tt.wantReason || failures[0].Mechanism == "" {
+					t.Errorf("AuthFailures() = %+v, want one failure for %q", failures, tt.wantReason)
+				}
+
+				// Mail is still refused after the failed login.
+				if err := c.Mail("sender@example.com", nil); !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+					t.Errorf("Mail() error = %v, want 530", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("Auth() error = %v", err)
+			}
+
+			data := createTestEmailData("sender@example.com", "recipient@example.com", "Authenticated")
+			if err := c.SendMail("sender@example.com", []string{"recipient@example.com"}, strings.NewReader(data)); err != nil {
+				t.Fatalf("SendMail() error = %v", err)
+			}
+
+			msgs := backend.store.List()
+			if len(msgs) != 1 {
+				t.Fatalf("stored %d messages, want 1", len(msgs))
+			}
+			mech, _, _ := tt.client.Start()
+			if v := msgs[0].view; !v.Authenticated || v.AuthUser != tt.wantUser || v.AuthMechanism != mech {
+				t.Errorf("auth fields = %v %q %q, want true %q %q", v.Authenticated, v.AuthUser, v.AuthMechanism, tt.wantUser, mech)
+			}
+			if failures := backend.AuthFailures(); len(failures) != 0 {
+				t.Errorf("AuthFailures() = %+v, want none", failures)
+			}
+		})
+	}
+}
+
+func TestSMTPAuthRequired(t *testing.T) {
+	cfg := testAuthConfig()
+	cfg.SMTPAuthRequired = true
+	_, addr := startTestAuthServer(t, cfg)
+
+	c, err := gosmtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	var smtpErr *gosmtp.SMTPError
+	if err := c.Mail("sender@example.com", nil); !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+		t.Errorf("Mail() before AUTH error = %v, want 530", err)
+	}
+}
+
+func TestCapturedPassword(t *testing.T) {
+	tests := []struct {
+		mode     string
+		password string
+		want     string
+	}{
+		{PasswordCaptureNone, "hunter2secret", ""},
+		{PasswordCaptureRedacted, "hunter2secret", "h***********t"},
+		{PasswordCaptureRedacted, "pässwörd", "p******d"},
+		{PasswordCaptureRedacted, "short", "*****"},
+		{PasswordCaptureRedacted, "", ""},
+		{PasswordCaptureSHA256, "", ""},
+		{PasswordCaptureSHA256, "secret", "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+	}
+
+	for _, tt := range tests {
+		a := smtpAuth{capturePassword: tt.mode}
+		if got := a.capturedPassword(tt.password); got != tt.want {
+			t.Errorf("capturedPassword(%q) with %s = %q, want %q", tt.password, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestSMTPAuthAcceptAny(t *testing.T) {
+	tests := []struct {
+		name         string
+		capture      string
+		client       sasl.Client
+		wantUser     string
+		wantPassword string
+	}{
+		{
+			"plain_unknown_user", PasswordCaptureSHA256, sasl.NewPlainClient("", "svc-orders", "secret"), "svc-orders",
+			"2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+		},
+		{"plain_wrong_password", PasswordCaptureRedacted, sasl.NewPlainClient("", "alice", "not-her-password"), "alice", "n**************d"},
+		{"plain_other_identity", PasswordCaptureNone, sasl.NewPlainClient("admin", "alice", "x"), "alice", ""},
+		{"login", PasswordCaptureRedacted, sasl.NewLoginClient("svc-mailer", "p@ssw0rd!"), "svc-mailer", "p*******!"},
+		{"cram_md5", PasswordCaptureSHA256, &cramMD5Client{"svc-legacy", "anything"}, "svc-legacy", ""},
+		{"xoauth2", PasswordCaptureRedacted, &xoauth2Client{"svc-cloud", "ya29.token"}, "svc-cloud", "y********n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testAuthConfig()
+			cfg.SMTPAuthAcceptAny = true
+			cfg.SMTPAuthCapturePassword = tt.capture
+			backend, addr := startTestAuthServer(t, cfg)
+
+			c, err := gosmtp.Dial(addr)
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer c.Close()
+
+			if err := c.Auth(tt.client); err != nil {
+				t.Fatalf("Auth() error = %v", err)
+			}
+			data := createTestEmailData("sender@example.com", "recipient@example.com", "Captured")
+			if err := c.SendMail("sender@example.com", []string{"recipient@example.com"}, strings.NewReader(data)); err != nil {
+				t.Fatalf("SendMail() error = %v", err)
+			}
+
+			msgs := backend.store.List()
+			if len(msgs) != 1 {
+				t.Fatalf("stored %d messages, want 1", len(msgs))
+			}
+			if v := msgs[0].view; !v.Authenticated || v.AuthUser != tt.wantUser || v.AuthPassword != tt.wantPassword {
+				t.Errorf("auth fields = %v %q %q, want true %q %q", v.Authenticated, v.AuthUser, v.AuthPassword, tt.wantUser, tt.wantPassword)
+			}
+		})
+	}
+}
+
+func TestSMTPAuthDisabled(t *testing.T) {
+	cfg := testAuthConfig()
+	cfg.SMTPAuthUsers = nil
+	_, addr := startTestAuthServer(t, cfg)
+
+	c, err := gosmtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Hello("localhost"); err != nil {
+		t.Fatalf("Hello() error = %v", err)
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		t.Error("AUTH is advertised without credentials")
+	}
+	data := createTestEmailData("sender@example.com", "recipient@example.com", "Anonymous")
+	if err := c.SendMail("sender@example.com", []string{"recipient@example.com"}, strings.NewReader(data)); err != nil {
+		t.Errorf("SendMail() error = %v", err)
+	}
+}
+
+// startTestAuthServer serves a new backend with the AUTH configuration of cfg and returns its address.
+func startTestAuthServer(t *testing.T, cfg *config.Config) (*smtpBackend, string) {
+	t.Helper()
+
+	auth, err := newSMTPAuth(cfg)
+	if err != nil {
+		t.Fatalf("newSMTPAuth() error = %v", err)
+	}
+	backend := newSMTPBackend()
+	backend.auth = auth
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	cfg.SMTPAllowInsecureAuth = true
+	serveTestSMTP(t, newSMTPServer(cfg, backend), l)
+
+	return backend, l.Addr().String()
+}
@@ -0,0 +1,72 @@
+package fakesmtpserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const benchmarkMessages = 10000
+
+// newBenchmarkBackend stores benchmarkMessages messages spread over 100 recipients.
+func newBenchmarkBackend(b *testing.B) *smtpBackend {
+	b.Helper()
+
+	backend := newSMTPBackend()
+	base := time.Now().Add(-benchmarkMessages * time.Second)
+	for i := range benchmarkMessages {
+		rcpt := fmt.Sprintf("user%d@example.com", i%100)
+		addTestMessage(b, backend, &smtpMessage{
+			data:         createTestEmailWithCC("sender@example.com", rcpt, "cc@example.com", fmt.Sprintf("Message %d", i)),
+			receivedTime: base.Add(time.Duration(i) * time.Second),
+			mailFrom:     "sender@example.com",
+			rcptTo:       []string{rcpt},
+			conn:         &smtpConnection{},
+		})
+	}
+
+	return backend
+}
+
+func BenchmarkGetAllData(b *testing.B) {
+	backend := newBenchmarkBackend(b)
+
+	for b.Loop() {
+		if got := len(backend.GetAllData()); got != benchmarkMessages {
+			b.Fatalf("GetAllData() = %d messages", got)
+		}
+	}
+}
+
+func BenchmarkListPage(b *testing.B) {
+	backend := newBenchmarkBackend(b)
+
+	for b.Loop() {
+		page, err := backend.List(messageFilter{}, listOptions{limit: 50, desc: true})
+		if err != nil || len(page.Messages) != 50 {
+			b.Fatalf("List() = %d messages, %v", len(page.Messages), err)
+		}
+	}
+}
+
+func BenchmarkSearchByField(b *testing.B) {
+	backend := newBenchmarkBackend(b)
+
+	for b.Loop() {
+		results, err := backend.SearchByField(FieldTo, "user42@example.com")
+		if err != nil || len(results) != benchmarkMessages/100 {
+			b.Fatalf("SearchByField() = %d results, %v", len(results), err)
+		}
+	}
+}
+
+func BenchmarkSearchPage(b *testing.B) {
+	backend := newBenchmarkBackend(b)
+
+	for b.Loop() {
+		page, err := backend.List(messageFilter{field: FieldTo, email: "user42@example.com"}, listOptions{limit: 20, desc: true})
+		if err != nil || len(page.Messages) != 20 {
+			b.Fatalf("List() = %d messages, %v", len(page.Messages), err)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package fakesmtpserver
 
 import (
-	"net/mail"
 	"strings"
 	"testing"
 	"time"
@@ -10,7 +9,7 @@ import (
 //nolint:maintidx // Comprehensive test coverage requires high complexity
 func TestEdgeCasesAndErrorHandling(t *testing.T) {
 	t.Run("empty_backend", func(t *testing.T) {
-		backend := &smtpBackend{}
+		backend := newSMTPBackend()
 		results, err := backend.SearchByField("to", "test@example.com")
 		if err != nil {
 			t.Errorf("SearchByField() on empty backend should not error, got: %v", err)
@@ -21,17 +20,19 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 	})
 
 	t.Run("session_with_empty_data", func(t *testing.T) {
-		backend := &smtpBackend{}
-		session := &smtpSession{
+		backend := newSMTPBackend()
+		msg := &smtpMessage{
 			data:         "", // Empty email data
 			receivedTime: time.Now(),
 			mailFrom:     "sender@example.com",
 			rcptTo:       []string{"recipient@example.com"},
-			clientAddr:   "192.168.1.100:12345",
-			clientHost:   "client.example.com",
-			tlsUsed:      false,
+			conn: &smtpConnection{
+				clientAddr: "192.168.1.100:12345",
+				clientHost: "client.example.com",
+				tlsUsed:    false,
+			},
 		}
-		backend.sessions = []*smtpSession{session}
+		addTestMessage(t, backend, msg)
 
 		// Should still find in SMTP data even if email data is empty
 		results, err := backend.SearchByField("from", "sender@example.com")
@@ -44,17 +45,19 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 	})
 
 	t.Run("session_with_malformed_email_data", func(t *testing.T) {
-		backend := &smtpBackend{}
-		session := &smtpSession{
+		backend := newSMTPBackend()
+		msg := &smtpMessage{
 			data:         "This is not a valid email format at all!!!", // Malformed email
 			receivedTime: time.Now(),
 			mailFrom:     "sender@example.com",
 			rcptTo:       []string{"recipient@example.com"},
-			clientAddr:   "192.168.1.100:12345",
-			clientHost:   "client.example.com",
-			tlsUsed:      false,
+			conn: &smtpConnection{
+				clientAddr: "192.168.1.100:12345",
+				clientHost: "client.example.com",
+				tlsUsed:    false,
+			},
 		}
-		backend.sessions = []*smtpSession{session}
+		addTestMessage(t, backend, msg)
 
 		// Should still work and find SMTP data
 		results, err := backend.SearchByField("from", "sender@example.com")
@@ -72,17 +75,19 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 	})
 
 	t.Run("case_sensitivity_comprehensive", func(t *testing.T) {
-		backend := &smtpBackend{}
-		session := &smtpSession{
+		backend := newSMTPBackend()
+		msg := &smtpMessage{
 			data:         createTestEmailData("Sender@Example.COM", "Recipient@Example.COM", "Test Subject"),
 			receivedTime: time.Now(),
 			mailFrom:     "SMTP-Sender@Example.COM",
 			rcptTo:       []string{"SMTP-Recipient@Example.COM"},
-			clientAddr:   "192.168.1.100:12345",
-			clientHost:   "client.example.com",
-			tlsUsed:      true,
+			conn: &smtpConnection{
+				clientAddr: "192.168.1.100:12345",
+				clientHost: "client.example.com",
+				tlsUsed:    true,
+			},
 		}
-		backend.sessions = []*smtpSession{session}
+		addTestMessage(t, backend, msg)
 
 		// Test various case combinations
 		testCases := []struct {
@@ -110,17 +115,19 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 	})
 
 	t.Run("unicode_and_special_characters", func(t *testing.T) {
-		backend := &smtpBackend{}
-		session := &smtpSession{
+		backend := newSMTPBackend()
+		msg := &smtpMessage{
 			data:         createTestEmailData("测试@example.com", "αβγ@example.com", "Test Subject with 中文"),
 			receivedTime: time.Now(),
 			mailFrom:     "测试@example.com",
 			rcptTo:       []string{"αβγ@example.com", "مرحبا@example.com"},
-			clientAddr:   "192.168.1.100:12345",
-			clientHost:   "client.example.com",
-			tlsUsed:      true,
+			conn: &smtpConnection{
+				clientAddr: "192.168.1.100:12345",
+				clientHost: "client.example.com",
+				tlsUsed:    true,
+			},
 		}
-		backend.sessions = []*smtpSession{session}
+		addTestMessage(t, backend, msg)
 
 		// Test unicode email addresses
 		results, err := backend.SearchByField("from", "测试@example.com")
@@ -142,17 +149,19 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 	})
 
 	t.Run("empty_email_addresses", func(t *testing.T) {
-		backend := &smtpBackend{}
-		session := &smtpSession{
+		backend := newSMTPBackend()
+		msg := &smtpMessage{
 			data:         "From: \r\nTo: \r\nSubject: Empty addresses\r\n\r\nTest",
 			receivedTime: time.Now(),
 			mailFrom:     "",           // Empty SMTP from
 			rcptTo:       []string{""}, // Empty SMTP to
-			clientAddr:   "192.168.1.100:12345",
-			clientHost:   "client.example.com",
-			tlsUsed:      false,
+			conn: &smtpConnection{
+				clientAddr: "192.168.1.100:12345",
+				clientHost: "client.example.com",
+				tlsUsed:    false,
+			},
 		}
-		backend.sessions = []*smtpSession{session}
+		addTestMessage(t, backend, msg)
 
 		// Searching for empty string should not crash and should find the empty SMTP from
 		results, err := backend.SearchByField("from", "")
@@ -174,64 +183,25 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 		}
 	})
 
-	t.Run("nil_and_empty_address_lists", func(t *testing.T) {
-		// Test helper functions with nil and empty inputs
-		if containsEmailInAddresses(nil, "test@example.com") {
-			t.Error("containsEmailInAddresses() should return false for nil addresses")
-		}
-
-		if containsEmailInAddresses([]*mail.Address{}, "test@example.com") {
-			t.Error("containsEmailInAddresses() should return false for empty addresses")
-		}
-
-		if containsEmailInStrings(nil, "test@example.com") {
-			t.Error("containsEmailInStrings() should return false for nil strings")
-		}
-
-		if containsEmailInStrings([]string{}, "test@example.com") {
-			t.Error("containsEmailInStrings() should return false for empty strings")
-		}
-	})
-
-	t.Run("mixed_valid_invalid_addresses", func(t *testing.T) {
-		addresses := []*mail.Address{
-			{Name: "Valid User", Address: "valid@example.com"},
-			{Name: "Invalid User", Address: ""}, // Empty address
-			{Name: "", Address: "another@example.com"},
-			nil, // Nil address (shouldn't happen but let's be safe)
-		}
-
-		// Should find valid addresses despite invalid ones
-		if !containsEmailInAddresses(addresses, "valid@example.com") {
-			t.Error("containsEmailInAddresses() should find valid address despite invalid ones")
-		}
-
-		if !containsEmailInAddresses(addresses, "another@example.com") {
-			t.Error("containsEmailInAddresses() should find second valid address")
-		}
-
-		if containsEmailInAddresses(addresses, "nonexistent@example.com") {
-			t.Error("containsEmailInAddresses() should not find nonexistent address")
-		}
-	})
-
 	t.Run("very_long_email_addresses", func(t *testing.T) {
 		// Test with very long email addresses
 		longLocal := strings.Repeat("a", 64)           // Max local part length
 		longDomain := strings.Repeat("b", 63) + ".com" // Long domain
 		longEmail := longLocal + "@" + longDomain
 
-		backend := &smtpBackend{}
-		session := &smtpSession{
+		backend := newSMTPBackend()
+		msg := &smtpMessage{
 			data:         createTestEmailData(longEmail, "recipient@example.com", "Test Subject"),
 			receivedTime: time.Now(),
 			mailFrom:     longEmail,
 			rcptTo:       []string{"recipient@example.com"},
-			clientAddr:   "192.168.1.100:12345",
-			clientHost:   "client.example.com",
-			tlsUsed:      true,
+			conn: &smtpConnection{
+				clientAddr: "192.168.1.100:12345",
+				clientHost: "client.example.com",
+				tlsUsed:    true,
+			},
 		}
-		backend.sessions = []*smtpSession{session}
+		addTestMessage(t, backend, msg)
 
 		results, err := backend.SearchByField("from", longEmail)
 		if err != nil {
@@ -244,8 +214,8 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 
 	t.Run("concurrent_access_safety", func(t *testing.T) {
 		// Test that concurrent access doesn't cause race conditions
-		backend := &smtpBackend{}
-		setupTestData(backend)
+		backend := newSMTPBackend()
+		setupTestData(t, backend)
 
 		// Run multiple searches concurrently
 		results := make(chan error, 10)
@@ -267,7 +237,7 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 	})
 
 	t.Run("search_field_boundary_cases", func(t *testing.T) {
-		backend := &smtpBackend{}
+		backend := newSMTPBackend()
 
 		// Test all invalid field names
 		invalidFields := []string{
@@ -300,24 +270,26 @@ func TestEdgeCasesAndErrorHandling(t *testing.T) {
 
 func TestDataIntegrityAfterSearch(t *testing.T) {
 	// Ensure that search operations don't modify the original data
-	backend := &smtpBackend{}
-	originalSession := &smtpSession{
+	backend := newSMTPBackend()
+	originalMsg := &smtpMessage{
 		data:         createTestEmailData("sender@example.com", "recipient@example.com", "Test Subject"),
 		receivedTime: time.Now(),
 		mailFrom:     "sender@example.com",
 		rcptTo:       []string{"recipient@example.com", "recipient2@example.com"},
-		clientAddr:   "192.168.1.100:12345",
-		clientHost:   "client.example.com",
-		tlsUsed:      true,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.100:12345",
+			clientHost: "client.example.com",
+			tlsUsed:    true,
+		},
 	}
 
-	backend.sessions = []*smtpSession{originalSession}
+	addTestMessage(t, backend, originalMsg)
 
 	// Store original values
-	originalData := originalSession.data
-	originalMailFrom := originalSession.mailFrom
-	originalRcptTo := make([]string, len(originalSession.rcptTo))
-	copy(originalRcptTo, originalSession.rcptTo)
+	originalData := originalMsg.data
+	originalMailFrom := originalMsg.mailFrom
+	originalRcptTo := make([]string, len(originalMsg.rcptTo))
+	copy(originalRcptTo, originalMsg.rcptTo)
 
 	// Perform search
 	_, err := backend.SearchByField("to", "recipient@example.com")
@@ -326,16 +298,16 @@ func TestDataIntegrityAfterSearch(t *testing.T) {
 	}
 
 	// Verify data hasn't changed
-	if originalSession.data != originalData {
+	if originalMsg.data != originalData {
 		t.Error("Search operation modified session data")
 	}
-	if originalSession.mailFrom != originalMailFrom {
+	if originalMsg.mailFrom != originalMailFrom {
 		t.Error("Search operation modified session mailFrom")
 	}
-	if len(originalSession.rcptTo) != len(originalRcptTo) {
+	if len(originalMsg.rcptTo) != len(originalRcptTo) {
 		t.Error("Search operation modified session rcptTo length")
 	}
-	for i, addr := range originalSession.rcptTo {
+	for i, addr := range originalMsg.rcptTo {
 		if addr != originalRcptTo[i] {
 			t.Error("Search operation modified session rcptTo content")
 		}
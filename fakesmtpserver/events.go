@@ -0,0 +1,112 @@
+package fakesmtpserver
+
+import (
+	"net/mail"
+	"time"
+)
+
+const (
+	// Event names published to /events subscribers.
+	eventMessageReceived = "message.received"
+	eventMessageDeleted  = "message.deleted"
+	eventMailboxCleared  = "mailbox.cleared"
+	eventMailboxRestored = "mailbox.restored"
+	// eventStreamReset is sent in place of events dropped from the journal;
+	// clients must list the messages again instead of applying the events that follow to what they have.
+	eventStreamReset = "stream.reset"
+
+	// maxJournalEvents bounds how far back a client can resume with Last-Event-ID.
+	maxJournalEvents = 1000
+)
+
+type (
+	// mailboxEvent is a single entry of the backend's event journal.
+	mailboxEvent struct {
+		ID   uint64
+		Name string
+		Data any
+	}
+
+	// smtpSummary is the short form of a message sent with message.received events.
+	smtpSummary struct {
+		ID           string          `json:"id"`
+		Subject      string          `json:"subject"`
+		FromAddress  []*mail.Address `json:"from"`
+		ToAddress    []*mail.Address `json:"to"`
+		SMTPFrom     string          `json:"smtpFrom"`
+		SMTPTo       []string        `json:"smtpTo"`
+		ReceivedTime time.Time       `json:"receivedTime"`
+		ConnectionID uint64          `json:"connectionId"`
+	}
+
+	// deletedEventData is sent with message.deleted events.
+	deletedEventData struct {
+		ID string `json:"id"`
+	}
+
+	// clearedEventData is sent with mailbox.cleared events.
+	clearedEventData struct {
+		Deleted int `json:"deleted"`
+	}
+
+	// resetEventData is sent with stream.reset events.
+	resetEventData struct {
+		Missed uint64 `json:"missed"` // Events dropped from the journal since the client's last event
+	}
+)
+
+func newSMTPSummary(view smtpView) smtpSummary {
+	return smtpSummary{
+		ID:           view.ID,
+		Subject:      view.Subject,
+		FromAddress:  view.FromAddressList,
+		ToAddress:    view.ToAddressList,
+		SMTPFrom:     view.SMTPFrom,
+		SMTPTo:       view.SMTPTo,
+		ReceivedTime: view.ReceivedTime,
+		ConnectionID: view.ConnectionID,
+	}
+}
+
+// publishLocked appends an event to the journal.
+// Waiters are woken by the store itself, so b.mux must be held for writing
+// across the store update and this call for them to see the new event.
+func (b *smtpBackend) publishLocked(name string, data any) {
+	b.eventSeq++
+	b.events = append(b.events, mailboxEvent{ID: b.eventSeq, Name: name, Data: data})
+	if over := len(b.events) - maxJournalEvents; over > 0 {
+		b.events = append(b.events[:0:0], b.events[over:]...)
+	}
+}
+
+// eventsSince returns the journaled events newer than lastID, together with a channel
+// that is closed when the stored messages change next.
+// If events after lastID were already dropped from the journal, a stream.reset event comes first.
+// It carries the ID of the last dropped event, so resuming from it does not reset again.
+func (b *smtpBackend) eventsSince(lastID uint64) ([]mailboxEvent, <-chan struct{}) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	var events []mailboxEvent
+	if len(b.events) > 0 && lastID < b.events[0].ID-1 {
+		dropped := b.events[0].ID - 1
+		events = append(events, mailboxEvent{ID: dropped, Name: eventStreamReset, Data: resetEventData{Missed: dropped - lastID}})
+	}
+	for i, ev := range b.events {
+		if ev.ID > lastID {
+			events = append(events, b.events[i:]...)
+
+			break
+		}
+	}
+
+	return events, b.store.Subscribe()
+}
+
+// lastEventID returns the ID of the most recently published event.
+func (b *smtpBackend) lastEventID() uint64 {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	return b.eventSeq
+}
@@ -0,0 +1,122 @@
+package fakesmtpserver
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// mboxDateLayout is the asctime layout of mbox "From " separator lines.
+	mboxDateLayout = "Mon Jan _2 15:04:05 2006"
+	// mboxDefaultSender is used in separator lines of messages without MAIL FROM.
+	mboxDefaultSender = "MAILER-DAEMON"
+	// exportIndexName is the JSON index written into ZIP exports.
+	exportIndexName = "index.json"
+)
+
+// exportIndexEntry is one element of the ZIP export index: the message view and its .eml file.
+type exportIndexEntry struct {
+	File string `json:"file"`
+	smtpView
+}
+
+func exportFilename(msg *smtpMessage) string {
+	return msg.id + ".eml"
+}
+
+// writeMbox writes messages to w in mboxrd format: each message is preceded by a
+// "From " separator line and lines matching ^>*From are quoted with one more ">".
+func writeMbox(w io.Writer, messages []*smtpMessage) error {
+	bw := bufio.NewWriter(w)
+
+	for _, msg := range messages {
+		sender := msg.mailFrom
+		if sender == "" {
+			sender = mboxDefaultSender
+		}
+		if _, err := fmt.Fprintf(bw, "From %s %s\n", sender, msg.receivedTime.UTC().Format(mboxDateLayout)); err != nil {
+			return fmt.Errorf("write mbox: %w", err)
+		}
+
+		if err := writeMboxrdBody(bw, msg.data); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write mbox: %w", err)
+	}
+
+	return nil
+}
+
+// writeMboxrdBody writes data with mboxrd quoting, followed by the blank line ending an mbox message.
+func writeMboxrdBody(w *bufio.Writer, data string) error {
+	for line := range strings.Lines(data) {
+		if isMboxFromLine(line) {
+			if err := w.WriteByte('>'); err != nil {
+				return fmt.Errorf("write mbox: %w", err)
+			}
+		}
+		if _, err := w.WriteString(line); err != nil {
+			return fmt.Errorf("write mbox: %w", err)
+		}
+	}
+
+	end := "\n"
+	if data != "" && !strings.HasSuffix(data, "\n") {
+		end = "\n\n"
+	}
+	if _, err := w.WriteString(end); err != nil {
+		return fmt.Errorf("write mbox: %w", err)
+	}
+
+	return nil
+}
+
+// isMboxFromLine reports whether line is a "From " line, possibly already quoted with ">".
+func isMboxFromLine(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, ">"), "From ")
+}
+
+// writeZip writes messages to w as a ZIP archive holding index.json and one <id>.eml file per message.
+func writeZip(w io.Writer, messages []*smtpMessage) error {
+	zw := zip.NewWriter(w)
+
+	index := make([]exportIndexEntry, len(messages))
+	for i, msg := range messages {
+		index[i] = exportIndexEntry{File: exportFilename(msg), smtpView: *msg.view}
+	}
+
+	f, err := zw.Create(exportIndexName)
+	if err != nil {
+		return fmt.Errorf("write zip: %w", err)
+	}
+	if err := json.NewEncoder(f).Encode(index); err != nil {
+		return fmt.Errorf("write zip index: %w", err)
+	}
+
+	for _, msg := range messages {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     exportFilename(msg),
+			Method:   zip.Deflate,
+			Modified: msg.receivedTime,
+		})
+		if err != nil {
+			return fmt.Errorf("write zip: %w", err)
+		}
+		if _, err := io.WriteString(f, msg.data); err != nil {
+			return fmt.Errorf("write zip: %w", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("write zip: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package fakesmtpserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriteMbox(t *testing.T) {
+	received := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	messages := []*smtpMessage{
+		{
+			mailFrom:     "sender@example.com",
+			receivedTime: received,
+			data: "Subject: Quoting\r\n\r\n" +
+				"From here on\r\n" +
+				">From the quoted line\r\n" +
+				">>From twice\r\n" +
+				"Not From at the start\r\n",
+		},
+		{
+			receivedTime: received.Add(time.Minute),
+			data:         "Subject: No trailing newline\n\nbody",
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeMbox(&buf, messages); err != nil {
+		t.Fatalf("writeMbox() error = %v", err)
+	}
+
+	want := "From sender@example.com Fri Jan  2 03:04:05 2026\n" +
+		"Subject: Quoting\r\n\r\n" +
+		">From here on\r\n" +
+		">>From the quoted line\r\n" +
+		">>>From twice\r\n" +
+		"Not From at the start\r\n" +
+		"\n" +
+		"From MAILER-DAEMON Fri Jan  2 03:05:05 2026\n" +
+		"Subject: No trailing newline\n\nbody\n" +
+		"\n"
+	if buf.String() != want {
+		t.Errorf("writeMbox() =\n%q\nwant\n%q", buf.String(), want)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	messages := []*smtpMessage{
+		newStoreTestMessage("a", "to@example.com", "First"),
+		newStoreTestMessage("b", "other@example.com", "Second"),
+	}
+
+	var buf bytes.Buffer
+	if err := writeZip(&buf, messages); err != nil {
+		t.Fatalf("writeZip() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("ReadAll(%s) error = %v", f.Name, err)
+		}
+		files[f.Name] = string(b)
+	}
+
+	if len(files) != 3 {
+		t.Errorf("archive has %d files, want 3", len(files))
+	}
+	for _, msg := range messages {
+		if files[msg.id+".eml"] != msg.data {
+			t.Errorf("%s.eml = %q, want %q", msg.id, files[msg.id+".eml"], msg.data)
+		}
+	}
+
+	var index []exportIndexEntry
+	if err := json.Unmarshal([]byte(files[exportIndexName]), &index); err != nil {
+		t.Fatalf("failed to unmarshal index: %v", err)
+	}
+	if len(index) != 2 || index[0].File != "a.eml" || index[0].ID != "a" || index[1].Subject != "Second" {
+		t.Errorf("unexpected index %+v", index)
+	}
+}
@@ -0,0 +1,241 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidTextQuery is returned when a full-text query contains no searchable terms.
+var ErrInvalidTextQuery = errors.New("invalid q: no searchable terms")
+
+const (
+	// subjectTermWeight is how often a term in the subject counts compared to one in the body.
+	subjectTermWeight = 2
+
+	// BM25 parameters, see messageIndex.searchText.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// textHit is a message matching a full-text query together with its relevance.
+type textHit struct {
+	msg   *smtpMessage
+	score float64
+}
+
+// textTokens splits s into normalized search terms. Text is NFKC-normalized, so full-width and
+// half-width forms match, and lowercased. Runs of letters and digits form words; Han, Hiragana,
+// Katakana and Hangul text, which is written without spaces, yields its single characters and
+// overlapping bigrams instead. Queries use textQueryTokens, which only needs the bigrams.
+func textTokens(s string) []string {
+	return tokenizeText(s, true)
+}
+
+// textQueryTokens splits a full-text query into the terms every matching message must contain.
+func textQueryTokens(s string) []string {
+	tokens := tokenizeText(s, false)
+	slices.Sort(tokens)
+
+	return slices.Compact(tokens)
+}
+
+func tokenizeText(s string, unigrams bool) []string {
+	var (
+		tokens []string
+		word   strings.Builder
+		cjk    []rune
+	)
+
+	flushWord := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1:
+			tokens = append(tokens, string(cjk))
+		case len(cjk) > 1:
+			if unigrams {
+				for _, r := range cjk {
+					tokens = append(tokens, string(r))
+				}
+			}
+			for i := range len(cjk) - 1 {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range norm.NFKC.String(s) {
+		r = unicode.ToLower(r)
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			flushCJK()
+			word.WriteRune(r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+
+	return tokens
+}
+
+// isCJK reports whether r belongs to a script written without spaces between words.
+func isCJK(r rune) bool {
+	switch r {
+	case 'ー', '々', '〆', 'ゝ', 'ゞ', 'ヽ', 'ヾ': // Prolonged sound and iteration marks are Common
+		return true
+	}
+
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// htmlText returns the text content of an HTML document, leaving out scripts and styles.
+func htmlText(s string) string {
+	var (
+		b    strings.Builder
+		skip int // Depth inside script and style elements
+	)
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.StartTagToken:
+			if name, _ := z.TagName(); isHTMLRawTextTag(name) {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); isHTMLRawTextTag(name) && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+				b.WriteByte(' ')
+			}
+		case html.SelfClosingTagToken, html.CommentToken, html.DoctypeToken:
+		}
+	}
+}
+
+func isHTMLRawTextTag(name []byte) bool {
+	return string(name) == "script" || string(name) == "style"
+}
+
+// textTerms returns the weighted frequency of every term in the subject, text body, HTML body
+// without markup and attachment filenames of view.
+func textTerms(view *smtpView) map[string]int {
+	terms := make(map[string]int)
+	add := func(s string, weight int) {
+		for _, t := range textTokens(s) {
+			terms[t] += weight
+		}
+	}
+
+	add(view.Subject, subjectTermWeight)
+	add(view.Text, 1)
+	if view.HTML != "" {
+		add(htmlText(view.HTML), 1)
+	}
+	for _, a := range view.Attachments {
+		add(a.Filename, 1)
+	}
+
+	return terms
+}
+
+// addText adds the terms of msg to the full-text index.
+func (idx *messageIndex) addText(msg *smtpMessage) {
+	n := 0
+	for term, tf := range textTerms(msg.view) {
+		postings, ok := idx.postings[term]
+		if !ok {
+			postings = make(map[*smtpMessage]int)
+			idx.postings[term] = postings
+		}
+		postings[msg] = tf
+		n += tf
+	}
+
+	idx.termCounts[msg] = n
+	idx.totalTerms += n
+}
+
+// removeText removes the terms of msg from the full-text index.
+func (idx *messageIndex) removeText(msg *smtpMessage) {
+	for term := range textTerms(msg.view) {
+		delete(idx.postings[term], msg)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+
+	idx.totalTerms -= idx.termCounts[msg]
+	delete(idx.termCounts, msg)
+}
+
+// searchText returns the messages containing every term, in arrival order, scored with BM25.
+func (idx *messageIndex) searchText(terms []string) []textHit {
+	if len(terms) == 0 || len(idx.messages) == 0 {
+		return nil
+	}
+
+	// Start from the rarest term so the candidate set is as small as possible.
+	lists := make([]map[*smtpMessage]int, len(terms))
+	for i, term := range terms {
+		lists[i] = idx.postings[term]
+		if len(lists[i]) == 0 {
+			return nil
+		}
+	}
+	slices.SortFunc(lists, func(a, b map[*smtpMessage]int) int { return len(a) - len(b) })
+
+	n := float64(len(idx.messages))
+	avgLen := float64(idx.totalTerms) / n
+
+	scores := make(map[*smtpMessage]float64, len(lists[0]))
+	for msg := range lists[0] {
+		score := 0.0
+		for _, postings := range lists {
+			tf, ok := postings[msg]
+			if !ok {
+				score = -1
+
+				break
+			}
+
+			idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			norm := 1 - bm25B + bm25B*float64(idx.termCounts[msg])/avgLen
+			score += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+		}
+		if score >= 0 {
+			scores[msg] = score
+		}
+	}
+
+	hits := make([]textHit, 0, len(scores))
+	for _, msg := range idx.messages {
+		if score, ok := scores[msg]; ok {
+			hits = append(hits, textHit{msg: msg, score: score})
+		}
+	}
+
+	return hits
+}
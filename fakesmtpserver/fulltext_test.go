@@ -0,0 +1,212 @@
+package fakesmtpserver
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		query []string
+	}{
+		{
+			name:  "words",
+			input: "Your Order #A-12345 shipped!",
+			want:  []string{"your", "order", "a", "12345", "shipped"},
+			query: []string{"12345", "a", "order", "shipped", "your"},
+		},
+		{
+			name:  "accents",
+			input: "Café naïve",
+			want:  []string{"café", "naïve"},
+			query: []string{"café", "naïve"},
+		},
+		{
+			name:  "japanese",
+			input: "東京都の注文",
+			want:  []string{"東", "京", "都", "の", "注", "文", "東京", "京都", "都の", "の注", "注文"},
+			query: []string{"の注", "京都", "東京", "注文", "都の"},
+		},
+		{
+			name:  "single_kanji",
+			input: "注",
+			want:  []string{"注"},
+			query: []string{"注"},
+		},
+		{
+			name:  "katakana_with_prolonged_sound_mark",
+			input: "パスワード",
+			want:  []string{"パ", "ス", "ワ", "ー", "ド", "パス", "スワ", "ワー", "ード"},
+			query: []string{"スワ", "パス", "ワー", "ード"},
+		},
+		{
+			name:  "mixed_scripts",
+			input: "注文番号ABC123です",
+			want:  []string{"注", "文", "番", "号", "注文", "文番", "番号", "abc123", "で", "す", "です"},
+			query: []string{"abc123", "です", "文番", "注文", "番号"},
+		},
+		{
+			name:  "full_width_and_half_width_katakana",
+			input: "ＡＢＣ１２３ ﾊﾟｽ",
+			want:  []string{"abc123", "パ", "ス", "パス"},
+			query: []string{"abc123", "パス"},
+		},
+		{
+			name:  "korean",
+			input: "비밀번호",
+			want:  []string{"비", "밀", "번", "호", "비밀", "밀번", "번호"},
+			query: []string{"밀번", "번호", "비밀"},
+		},
+		{
+			name:  "punctuation_only",
+			input: "!!! --- 。、",
+			want:  nil,
+			query: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := textTokens(tt.input); !slices.Equal(got, tt.want) {
+				t.Errorf("textTokens(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if got := textQueryTokens(tt.input); !slices.Equal(got, tt.query) {
+				t.Errorf("textQueryTokens(%q) = %q, want %q", tt.input, got, tt.query)
+			}
+		})
+	}
+}
+
+func TestHTMLText(t *testing.T) {
+	got := htmlText(`<html><head><style>p { color: red }</style><script>var x = "hidden";</script></head>` +
+		`<body><p>Order&nbsp;<b>42</b></p><!-- comment --><img src="a.png" alt="logo"/>done</body></html>`)
+
+	if strings.Contains(got, "hidden") || strings.Contains(got, "color") || strings.Contains(got, "comment") {
+		t.Errorf("htmlText() kept script, style or comment content: %q", got)
+	}
+	if tokens := textTokens(got); !slices.Equal(tokens, []string{"order", "42", "done"}) {
+		t.Errorf("htmlText() tokens = %q, want [order 42 done]", tokens)
+	}
+}
+
+func TestFullTextIndex(t *testing.T) {
+	newMsg := func(id, data string) *smtpMessage {
+		return &smtpMessage{id: id, data: data, receivedTime: time.Now(), conn: &smtpConnection{}}
+	}
+
+	idx := newMessageIndex()
+	idx.add(newMsg("plain", "Subject: Receipt\r\n\r\nOrder 12345 has shipped.\r\n"))
+	idx.add(newMsg("subject", "Subject: Order 12345\r\n\r\nThanks.\r\n"))
+	idx.add(newMsg("html", "Subject: News\r\nContent-Type: text/html\r\n\r\n<p>Your <b>order</b> is ready</p><script>12345</script>\r\n"))
+	idx.add(newMsg("japanese", "Subject: =?UTF-8?B?44GU5rOo5paH44Gu56K66KqN?=\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n東京都への配送です。\r\n"))
+	idx.add(newMsg("attachment", "Subject: Files\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n"+
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n"+
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice-2026.pdf\r\n\r\n%PDF\r\n--b--\r\n"))
+
+	search := func(q string) []string {
+		var ids []string
+		for _, hit := range idx.searchText(textQueryTokens(q)) {
+			ids = append(ids, hit.msg.id)
+		}
+
+		return ids
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"12345", []string{"plain", "subject"}},
+		{"ORDER", []string{"plain", "subject", "html"}},
+		{"order shipped", []string{"plain"}},
+		{"ご注文", []string{"japanese"}},
+		{"注文の確認", []string{"japanese"}},
+		{"東京都", []string{"japanese"}},
+		{"京", []string{"japanese"}},
+		{"大阪", nil},
+		{"invoice 2026", []string{"attachment"}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		if got := search(tt.q); !slices.Equal(got, tt.want) {
+			t.Errorf("searchText(%q) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	// A subject match weighs more than the same term in a longer body
+	hits := idx.searchText(textQueryTokens("12345"))
+	if hits[1].score <= hits[0].score {
+		t.Errorf("subject hit score %f should exceed body hit score %f", hits[1].score, hits[0].score)
+	}
+
+	idx.remove("subject")
+	if got := search("12345"); !slices.Equal(got, []string{"plain"}) {
+		t.Errorf("searchText() after remove = %v, want [plain]", got)
+	}
+
+	for _, id := range []string{"plain", "html", "japanese", "attachment"} {
+		idx.remove(id)
+	}
+	if len(idx.postings) != 0 || len(idx.termCounts) != 0 || idx.totalTerms != 0 {
+		t.Errorf("index not empty after removing everything: %d terms, %d counts, %d total",
+			len(idx.postings), len(idx.termCounts), idx.totalTerms)
+	}
+}
+
+func TestListFullTextRelevance(t *testing.T) {
+	backend := newSMTPBackend()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, data := range []string{
+		"Subject: Weekly digest\r\n\r\nMany words about many things and one refund.\r\n",
+		"Subject: Refund issued\r\n\r\nYour refund is on its way.\r\n",
+		"Subject: Hello\r\n\r\nNothing to see.\r\n",
+	} {
+		addTestMessage(t, backend, &smtpMessage{
+			data:         data,
+			receivedTime: base.Add(time.Duration(i) * time.Minute),
+			conn:         &smtpConnection{},
+		})
+	}
+
+	subjects := func(page messagePage) []string {
+		var s []string
+		for _, v := range page.Messages {
+			s = append(s, v.Subject)
+		}
+
+		return s
+	}
+
+	page, err := backend.List(messageFilter{text: "refund"}, listOptions{relevance: true})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if got := subjects(page); !slices.Equal(got, []string{"Refund issued", "Weekly digest"}) || page.Total != 2 {
+		t.Errorf("relevance order = %v (total %d)", got, page.Total)
+	}
+
+	page, err = backend.List(messageFilter{text: "refund"}, listOptions{desc: true})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if got := subjects(page); !slices.Equal(got, []string{"Refund issued", "Weekly digest"}) {
+		t.Errorf("time order = %v", got)
+	}
+
+	page, err = backend.List(messageFilter{text: "refund"}, listOptions{relevance: true, offset: 1, limit: 1})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if got := subjects(page); !slices.Equal(got, []string{"Weekly digest"}) || page.Total != 2 || page.NextCursor != "" {
+		t.Errorf("second relevance page = %v (total %d, cursor %q)", got, page.Total, page.NextCursor)
+	}
+
+	if _, err := backend.List(messageFilter{}, listOptions{relevance: true}); err == nil {
+		t.Error("List() with relevance ordering but no full-text query should fail")
+	}
+}
@@ -0,0 +1,75 @@
+package fakesmtpserver
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// registerAttachmentHandlers registers all attachment-related HTTP endpoints.
+func registerAttachmentHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /messages/{id}/attachments", handleListAttachments)
+	mux.HandleFunc("GET /messages/{id}/attachments/{index}", handleDownloadAttachment)
+	mux.HandleFunc("GET /messages/{id}/attachments/name/{filename}", handleDownloadAttachmentByName)
+}
+
+// handleListAttachments handles the endpoint that returns the attachment metadata of a captured email.
+func handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	view, ok := sharedBackend.GetByID(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "message not found")
+
+		return
+	}
+
+	attachments := view.Attachments
+	if attachments == nil {
+		attachments = []*smtpViewAttachment{}
+	}
+
+	writeJSON(w, attachments)
+}
+
+// handleDownloadAttachment handles the endpoint that downloads one attachment by its index.
+func handleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid attachment index")
+
+		return
+	}
+
+	writeAttachment(w, r.PathValue("id"), partByIndex(index))
+}
+
+// handleDownloadAttachmentByName handles the endpoint that downloads one attachment by its filename,
+// which may look like an index.
+func handleDownloadAttachmentByName(w http.ResponseWriter, r *http.Request) {
+	writeAttachment(w, r.PathValue("id"), partByFilename(r.PathValue("filename")))
+}
+
+// writeAttachment writes the part of the message with the given ID that find selects as a download.
+func writeAttachment(w http.ResponseWriter, id string, find partFinder) {
+	attachment, content, ok := sharedBackend.GetAttachment(id, find)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "attachment not found")
+
+		return
+	}
+
+	filename := attachment.Filename
+	if filename == "" {
+		filename = "part-" + strconv.Itoa(attachment.Index)
+	}
+
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
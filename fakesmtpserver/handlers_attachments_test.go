@@ -0,0 +1,174 @@
+package fakesmtpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+)
+
+const (
+	testPDFContent = "%PDF-1.4\n\x00\x01\x02\xff binary invoice content\n%%EOF"
+	testPNGContent = "\x89PNG\r\n\x1a\n fake image"
+)
+
+func TestHandleAttachments(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	msg := &smtpMessage{
+		id:           newMessageID(),
+		data:         createTestEmailWithAttachments(t),
+		receivedTime: time.Now(),
+		mailFrom:     "billing@example.com",
+		rcptTo:       []string{"customer@example.com"},
+		conn:         &smtpConnection{},
+	}
+	testBackend := newSMTPBackend()
+	addTestMessage(t, testBackend, msg)
+	sharedBackend = testBackend
+
+	mux := http.NewServeMux()
+	registerAttachmentHandlers(mux)
+
+	t.Run("list", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/messages/"+msg.id+"/attachments", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("handleListAttachments() status = %d, want %d", w.Code, http.StatusOK)
+		}
+
+		var attachments []smtpViewAttachment
+		if err := json.Unmarshal(w.Body.Bytes(), &attachments); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(attachments) != 2 {
+			t.Fatalf("handleListAttachments() = %d attachments, want 2", len(attachments))
+		}
+
+		pdfSum := sha256.Sum256([]byte(testPDFContent))
+		pdf := attachments[0]
+		if pdf.Filename != "invoice.pdf" || pdf.ContentType != "application/pdf" || pdf.Disposition != "attachment" {
+			t.Errorf("unexpected attachment metadata: %+v", pdf)
+		}
+		if pdf.Size != len(testPDFContent) || pdf.SHA256 != hex.EncodeToString(pdfSum[:]) {
+			t.Errorf("unexpected attachment size/digest: %+v", pdf)
+		}
+
+		logo := attachments[1]
+		if logo.Index != 1 || logo.Filename != "logo.png" || logo.ContentID != "logo@example.com" || logo.Disposition != "inline" {
+			t.Errorf("unexpected inline metadata: %+v", logo)
+		}
+	})
+
+	tests := []struct {
+		name           string
+		ref            string
+		expectedStatus int
+		wantContent    string
+		wantType       string
+	}{
+		{name: "by_index", ref: "0", expectedStatus: http.StatusOK, wantContent: testPDFContent, wantType: "application/pdf"},
+		{name: "by_filename", ref: "name/logo.png", expectedStatus: http.StatusOK, wantContent: testPNGContent, wantType: "image/png"},
+		{name: "index_out_of_range", ref: "2", expectedStatus: http.StatusNotFound},
+		{name: "filename_on_index_route", ref: "logo.png", expectedStatus: http.StatusBadRequest},
+		{name: "index_on_filename_route", ref: "name/0", expectedStatus: http.StatusNotFound},
+		{name: "unknown_filename", ref: "name/missing.txt", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/messages/"+msg.id+"/attachments/"+tt.ref, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("handleDownloadAttachment() status = %d, want %d", w.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			if w.Body.String() != tt.wantContent {
+				t.Errorf("handleDownloadAttachment() content differs from the original")
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("handleDownloadAttachment() Content-Type = %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+
+	t.Run("unknown_message", func(t *testing.T) {
+		for _, path := range []string{"/messages/unknown/attachments", "/messages/unknown/attachments/0"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("GET %s status = %d, want %d", path, w.Code, http.StatusNotFound)
+			}
+		}
+	})
+}
+
+func TestPartFinders(t *testing.T) {
+	parts := []*enmime.Part{
+		{FileName: "a.txt"},
+		{FileName: "0"},
+		{FileName: ""},
+	}
+
+	tests := []struct {
+		name      string
+		find      partFinder
+		wantIndex int
+		wantOK    bool
+	}{
+		{name: "index", find: partByIndex(0), wantIndex: 0, wantOK: true},
+		{name: "index_out_of_range", find: partByIndex(3), wantOK: false},
+		{name: "negative_index", find: partByIndex(-1), wantOK: false},
+		{name: "filename", find: partByFilename("a.txt"), wantIndex: 0, wantOK: true},
+		{name: "numeric_filename", find: partByFilename("0"), wantIndex: 1, wantOK: true}, // not the part at index 0
+		{name: "empty_filename", find: partByFilename(""), wantOK: false},
+	}
+
+	for _, tt := range tests {
+		i, ok := tt.find(parts)
+		if ok != tt.wantOK || (ok && i != tt.wantIndex) {
+			t.Errorf("%s = %d, %v, want %d, %v", tt.name, i, ok, tt.wantIndex, tt.wantOK)
+		}
+	}
+}
+
+// createTestEmailWithAttachments builds a message with a PDF attachment and an inline PNG.
+func createTestEmailWithAttachments(t *testing.T) string {
+	t.Helper()
+
+	root, err := enmime.Builder().
+		From("Billing", "billing@example.com").
+		To("Customer", "customer@example.com").
+		Subject("Your invoice").
+		Text([]byte("Please find your invoice attached.")).
+		HTML([]byte(`<p>Invoice</p><img src="cid:logo@example.com">`)).
+		AddAttachment([]byte(testPDFContent), "application/pdf", "invoice.pdf").
+		AddInline([]byte(testPNGContent), "image/png", "logo.png", "logo@example.com").
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build test email: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatalf("failed to encode test email: %v", err)
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,21 @@
+package fakesmtpserver
+
+import (
+	"net/http"
+)
+
+// registerAuthHandlers registers all AUTH-related HTTP endpoints.
+func registerAuthHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/failures", handleAuthFailures)
+	mux.HandleFunc("DELETE /auth/failures", handleClearAuthFailures)
+}
+
+// handleAuthFailures handles the endpoint that lists the failed logins, oldest first.
+func handleAuthFailures(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, sharedBackend.AuthFailures())
+}
+
+// handleClearAuthFailures handles the endpoint that clears the log of failed logins.
+func handleClearAuthFailures(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, deleteResponse{Deleted: sharedBackend.ClearAuthFailures()})
+}
@@ -0,0 +1,50 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleAuthFailures(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	sharedBackend = newSMTPBackend()
+	sharedBackend.recordAuthFailure(authFailure{Time: time.Now(), Mechanism: AuthPlain, Username: "alice", Reason: errAuthWrongPassword.Error()})
+	sharedBackend.recordAuthFailure(authFailure{Time: time.Now(), Mechanism: AuthLogin, Username: "bob", Reason: errAuthUnknownUser.Error()})
+
+	mux := http.NewServeMux()
+	registerAuthHandlers(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/failures", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handleAuthFailures() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var failures []authFailure
+	if err := json.Unmarshal(w.Body.Bytes(), &failures); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(failures) != 2 || failures[0].Username != "alice" || failures[1].Reason != "unknown user" {
+		t.Errorf("failures = %+v, want alice then bob", failures)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/auth/failures", nil))
+	var resp deleteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Deleted != 2 || len(sharedBackend.AuthFailures()) != 0 {
+		t.Errorf("DELETE deleted %d, %d left, want 2 and none", resp.Deleted, len(sharedBackend.AuthFailures()))
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/failures", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handleAuthFailures() POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,97 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseKeepAliveInterval is how often a comment line is sent on idle streams,
+// so proxies and clients don't consider the connection dead.
+const sseKeepAliveInterval = 15 * time.Second
+
+// registerEventHandlers registers all event-stream HTTP endpoints.
+func registerEventHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /events", handleEvents)
+}
+
+// handleEvents streams mailbox events as Server-Sent Events.
+// Clients resume with the Last-Event-ID header (or lastEventId query parameter);
+// without it only events published after connecting are sent. The sequence restarts with the process,
+// so an ID ahead of the current one was issued before a restart and is treated like no ID.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming unsupported")
+
+		return
+	}
+
+	lastID := sharedBackend.lastEventID()
+	resumeFrom := r.Header.Get("Last-Event-ID")
+	if resumeFrom == "" {
+		resumeFrom = r.URL.Query().Get("lastEventId")
+	}
+	if resumeFrom != "" {
+		id, err := strconv.ParseUint(resumeFrom, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+
+			return
+		}
+		if id < lastID {
+			lastID = id
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		events, ch := sharedBackend.eventsSince(lastID)
+		for _, ev := range events {
+			if err := writeSSEEvent(w, ev); err != nil {
+				slog.Info("failed to write event", "error", err)
+
+				return
+			}
+			lastID = ev.ID
+		}
+		flusher.Flush()
+
+		select {
+		case <-ch:
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes ev in the text/event-stream format.
+func writeSSEEvent(w io.Writer, ev mailboxEvent) error {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Name, data); err != nil {
+		return fmt.Errorf("write event: %w", err)
+	}
+
+	return nil
+}
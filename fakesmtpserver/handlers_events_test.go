@@ -0,0 +1,212 @@
+package fakesmtpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSSEEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// readSSEEvent reads the next event from an SSE stream, skipping comments.
+func readSSEEvent(t *testing.T, r *bufio.Reader) testSSEEvent {
+	t.Helper()
+
+	var ev testSSEEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && ev.name != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func openEventStream(t *testing.T, url, lastEventID string) *bufio.Reader {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /events status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("GET /events Content-Type = %s, want text/event-stream", got)
+	}
+
+	return bufio.NewReader(resp.Body)
+}
+
+func TestHandleEvents(t *testing.T) {
+	originalBackend := sharedBackend
+	t.Cleanup(func() { sharedBackend = originalBackend })
+
+	testBackend := newSMTPBackend()
+	sharedBackend = testBackend
+
+	mux := http.NewServeMux()
+	registerEventHandlers(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close) // runs after the streams opened below are closed
+
+	newMessage := func(rcpt string) *smtpMessage {
+		return &smtpMessage{
+			id:           newMessageID(),
+			data:         createTestEmailData("sender@example.com", rcpt, "Hello "+rcpt),
+			receivedTime: time.Now(),
+			mailFrom:     "sender@example.com",
+			rcptTo:       []string{rcpt},
+			conn:         &smtpConnection{id: 3},
+		}
+	}
+
+	first := newMessage("first@example.com")
+	addTestMessage(t, testBackend, first)
+
+	stream := openEventStream(t, server.URL+"/events", "")
+
+	// Only events published after connecting are streamed
+	second := newMessage("second@example.com")
+	addTestMessage(t, testBackend, second)
+
+	ev := readSSEEvent(t, stream)
+	if ev.name != eventMessageReceived || ev.id != "2" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	var summary smtpSummary
+	if err := json.Unmarshal([]byte(ev.data), &summary); err != nil {
+		t.Fatalf("failed to unmarshal summary: %v", err)
+	}
+	if summary.ID != second.id || summary.Subject != "Hello second@example.com" || summary.ConnectionID != 3 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	if _, err := testBackend.DeleteByID(first.id); err != nil {
+		t.Fatalf("DeleteByID() error = %v", err)
+	}
+	ev = readSSEEvent(t, stream)
+	if ev.name != eventMessageDeleted || !strings.Contains(ev.data, first.id) {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	if _, err := testBackend.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll() error = %v", err)
+	}
+	ev = readSSEEvent(t, stream)
+	if ev.name != eventMailboxCleared || ev.data != `{"deleted":1}` {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	// Resuming replays everything after the given ID
+	resumed := openEventStream(t, server.URL+"/events", "1")
+	for _, want := range []string{eventMessageReceived, eventMessageDeleted, eventMailboxCleared} {
+		if ev := readSSEEvent(t, resumed); ev.name != want {
+			t.Errorf("resumed event = %s, want %s", ev.name, want)
+		}
+	}
+
+	// An ID from before a restart is ahead of the journal; new events must still arrive
+	stale := openEventStream(t, server.URL+"/events", "57")
+	third := newMessage("third@example.com")
+	addTestMessage(t, testBackend, third)
+	if ev := readSSEEvent(t, stale); ev.name != eventMessageReceived || ev.id != "5" || !strings.Contains(ev.data, third.id) {
+		t.Errorf("event after stale Last-Event-ID = %+v", ev)
+	}
+}
+
+func TestHandleEventsInvalidRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		lastEventID    string
+		expectedStatus int
+	}{
+		{name: "invalid_method", method: http.MethodPost, expectedStatus: http.StatusMethodNotAllowed},
+		{name: "invalid_last_event_id", method: http.MethodGet, lastEventID: "abc", expectedStatus: http.StatusBadRequest},
+	}
+
+	mux := http.NewServeMux()
+	registerEventHandlers(mux)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/events", nil)
+			req.Header.Set("Last-Event-ID", tt.lastEventID)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("handleEvents() status = %d, want %d", w.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestEventJournalIsBounded(t *testing.T) {
+	backend := newSMTPBackend()
+
+	backend.mux.Lock()
+	for range maxJournalEvents + 10 {
+		backend.publishLocked(eventMailboxCleared, clearedEventData{})
+	}
+	backend.mux.Unlock()
+
+	// Resuming from before the dropped events resets the stream first
+	events, _ := backend.eventsSince(3)
+	if len(events) != maxJournalEvents+1 {
+		t.Fatalf("eventsSince(3) = %d events, want %d", len(events), maxJournalEvents+1)
+	}
+	if reset := events[0]; reset.ID != 10 || reset.Name != eventStreamReset || reset.Data != (resetEventData{Missed: 7}) {
+		t.Errorf("first event = %+v, want %s with ID 10 and 7 missed", reset, eventStreamReset)
+	}
+	if events[1].ID != 11 {
+		t.Errorf("oldest journaled event = %d, want 11", events[1].ID)
+	}
+
+	// Resuming from the reset event continues with the journal
+	events, _ = backend.eventsSince(10)
+	if len(events) != maxJournalEvents || events[0].ID != 11 {
+		t.Errorf("eventsSince(10) = %d events from %d, want %d from 11", len(events), events[0].ID, maxJournalEvents)
+	}
+
+	events, _ = backend.eventsSince(backend.lastEventID())
+	if len(events) != 0 {
+		t.Errorf("eventsSince(last) = %d events, want 0", len(events))
+	}
+}
@@ -0,0 +1,62 @@
+package fakesmtpserver
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// registerExportHandlers registers all export-related HTTP endpoints.
+func registerExportHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/export.mbox", handleExport("application/mbox", "messages.mbox", writeMbox))
+	mux.HandleFunc("/export.zip", handleExport("application/zip", "messages.zip", writeZip))
+}
+
+// handleExport returns a handler that streams the messages matching the optional field, email and query
+// filter, ordered and paged like the list endpoints, as a file written by write.
+func handleExport(contentType, filename string, write func(io.Writer, []*smtpMessage) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+			return
+		}
+
+		values := r.URL.Query()
+		filter, err := parseFilterParameters(values)
+		if err != nil {
+			writeBadRequest(w, err)
+
+			return
+		}
+		if err := parseTimeRange(values, &filter); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		opts, err := parseListOptions(values)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		messages, _, err := sharedBackend.listMessages(filter, opts)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		w.WriteHeader(http.StatusOK)
+
+		// The status is sent already, so a failure can only cut the download short.
+		if err := write(w, messages); err != nil {
+			slog.Info("export error", "filename", filename, "error", err)
+		}
+	}
+}
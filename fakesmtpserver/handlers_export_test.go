@@ -0,0 +1,118 @@
+package fakesmtpserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleExport(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	mux := http.NewServeMux()
+	registerExportHandlers(mux)
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		expectedStatus  int
+		expectedType    string
+		expectedEntries int
+	}{
+		{
+			name:            "mbox_all",
+			method:          http.MethodGet,
+			path:            "/export.mbox",
+			expectedStatus:  http.StatusOK,
+			expectedType:    "application/mbox",
+			expectedEntries: 3,
+		},
+		{
+			name:            "mbox_filtered",
+			method:          http.MethodGet,
+			path:            "/export.mbox?field=from&email=sender2@example.com",
+			expectedStatus:  http.StatusOK,
+			expectedType:    "application/mbox",
+			expectedEntries: 1,
+		},
+		{
+			name:            "zip_all",
+			method:          http.MethodGet,
+			path:            "/export.zip",
+			expectedStatus:  http.StatusOK,
+			expectedType:    "application/zip",
+			expectedEntries: 3,
+		},
+		{
+			name:            "zip_limited",
+			method:          http.MethodGet,
+			path:            "/export.zip?limit=2&sort=desc",
+			expectedStatus:  http.StatusOK,
+			expectedType:    "application/zip",
+			expectedEntries: 2,
+		},
+		{
+			name:           "invalid_field",
+			method:         http.MethodGet,
+			path:           "/export.mbox?field=subject&email=a@example.com",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid_sort",
+			method:         http.MethodGet,
+			path:           "/export.zip?sort=random",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "method_not_allowed",
+			method:         http.MethodPost,
+			path:           "/export.zip",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.expectedStatus, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			if got := w.Header().Get("Content-Type"); got != tt.expectedType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.expectedType)
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+				t.Errorf("Content-Disposition = %q, want an attachment", w.Header().Get("Content-Disposition"))
+			}
+
+			var entries int
+			if tt.expectedType == "application/mbox" {
+				entries = strings.Count("\n"+w.Body.String(), "\nFrom ")
+			} else {
+				zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+				if err != nil {
+					t.Fatalf("zip.NewReader() error = %v", err)
+				}
+				entries = len(zr.File) - 1 // index.json
+			}
+			if entries != tt.expectedEntries {
+				t.Errorf("exported %d messages, want %d", entries, tt.expectedEntries)
+			}
+		})
+	}
+}
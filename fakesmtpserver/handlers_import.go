@@ -0,0 +1,144 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxImportBytes bounds the size of an import request body.
+const maxImportBytes = 32 << 20
+
+// importResponse lists the messages an import request stored.
+type importResponse struct {
+	Messages []smtpSummary `json:"messages"`
+}
+
+// handleImport stores the messages of a POST /messages request as if they had arrived over SMTP.
+// The body is a raw RFC 5322 message, an mbox file, or a multipart/form-data upload of such files.
+// The optional from and to parameters set the SMTP envelope; to may be repeated or comma separated.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	files, err := readImportBody(r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	env, err := parseImportEnvelope(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	messages, err := sharedBackend.importMessages(r.RemoteAddr, env, files)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEmptyMessage), errors.Is(err, ErrInvalidMbox):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+
+			return
+		case errors.Is(err, ErrImportTooLarge):
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+
+			return
+		}
+
+		slog.Error("import error", "imported", len(messages), "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "import failed")
+
+		return
+	}
+
+	resp := importResponse{Messages: make([]smtpSummary, len(messages))}
+	for i, msg := range messages {
+		resp.Messages[i] = newSMTPSummary(*msg.view)
+	}
+
+	writeJSONStatus(w, http.StatusCreated, resp)
+}
+
+// readImportBody returns the uploaded files of a multipart/form-data request, or the whole body otherwise.
+func readImportBody(r *http.Request) ([]importFile, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // reported to the client as is
+		}
+
+		return []importFile{{name: "request body", data: string(b)}}, nil
+	}
+
+	if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+		return nil, err //nolint:wrapcheck // reported to the client as is
+	}
+
+	// Form fields are processed in name order, files in upload order.
+	fields := make([]string, 0, len(r.MultipartForm.File))
+	for field := range r.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	var files []importFile
+	for _, field := range fields {
+		for _, fh := range r.MultipartForm.File[field] {
+			f, err := fh.Open()
+			if err != nil {
+				return nil, err //nolint:wrapcheck // reported to the client as is
+			}
+			b, err := io.ReadAll(f)
+			_ = f.Close()
+			if err != nil {
+				return nil, err //nolint:wrapcheck // reported to the client as is
+			}
+			files = append(files, importFile{name: fh.Filename, data: string(b)})
+		}
+	}
+	if len(files) == 0 {
+		return nil, ErrEmptyMessage
+	}
+
+	return files, nil
+}
+
+// parseImportEnvelope extracts the optional from and to parameters from the query string or form.
+func parseImportEnvelope(r *http.Request) (importEnvelope, error) {
+	var env importEnvelope
+
+	if from := strings.TrimSpace(r.FormValue("from")); from != "" {
+		if !strings.Contains(from, "@") {
+			return importEnvelope{}, ErrInvalidEmailFormat
+		}
+		env.from = from
+	}
+
+	for _, v := range r.Form["to"] {
+		for _, to := range strings.Split(v, ",") {
+			to = strings.TrimSpace(to)
+			if to == "" {
+				continue
+			}
+			if !strings.Contains(to, "@") {
+				return importEnvelope{}, ErrInvalidEmailFormat
+			}
+			env.to = append(env.to, to)
+		}
+	}
+
+	return env, nil
+}
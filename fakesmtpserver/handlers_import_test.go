@@ -0,0 +1,181 @@
+package fakesmtpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHandleImport(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	raw := createTestEmailWithCC("sender@example.com", "to@example.com", "copy@example.com", "Imported")
+	mbox := "From sender@example.com Thu Jan  1 00:00:00 2026\n" + raw + "\n" +
+		"From sender@example.com Thu Jan  1 00:00:00 2026\n" + createTestEmailData("sender@example.com", "other@example.com", "Second") + "\n"
+	large := createTestEmailData("sender@example.com", "to@example.com", strings.Repeat("Large ", 200))
+
+	multipartBody := func(files ...string) (string, *bytes.Buffer) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for i, f := range files {
+			fw, err := mw.CreateFormFile("file", "message"+string(rune('a'+i))+".eml")
+			if err != nil {
+				t.Fatalf("CreateFormFile() error = %v", err)
+			}
+			_, _ = fw.Write([]byte(f))
+		}
+		_ = mw.WriteField("to", "form@example.com")
+		_ = mw.Close()
+
+		return mw.FormDataContentType(), body
+	}
+
+	tests := []struct {
+		name            string
+		query           string
+		body            func() (string, *bytes.Buffer)
+		maxMessageBytes int64
+		expectedStatus  int
+		expectedError   string
+		expectedFrom    []string
+		expectedTo      [][]string
+	}{
+		{
+			name:           "raw_message",
+			body:           func() (string, *bytes.Buffer) { return "message/rfc822", bytes.NewBufferString(raw) },
+			expectedStatus: http.StatusCreated,
+			expectedFrom:   []string{"sender@example.com"},
+			expectedTo:     [][]string{{"to@example.com", "copy@example.com"}},
+		},
+		{
+			name:           "raw_message_with_envelope",
+			query:          "?from=bounce@example.com&to=a@example.com,b@example.com&to=c@example.com",
+			body:           func() (string, *bytes.Buffer) { return "message/rfc822", bytes.NewBufferString(raw) },
+			expectedStatus: http.StatusCreated,
+			expectedFrom:   []string{"bounce@example.com"},
+			expectedTo:     [][]string{{"a@example.com", "b@example.com", "c@example.com"}},
+		},
+		{
+			name:           "mbox",
+			body:           func() (string, *bytes.Buffer) { return "application/mbox", bytes.NewBufferString(mbox) },
+			expectedStatus: http.StatusCreated,
+			expectedFrom:   []string{"sender@example.com", "sender@example.com"},
+			expectedTo:     [][]string{{"to@example.com", "copy@example.com"}, {"other@example.com"}},
+		},
+		{
+			name:           "multipart",
+			body:           func() (string, *bytes.Buffer) { return multipartBody(raw, mbox) },
+			expectedStatus: http.StatusCreated,
+			expectedFrom:   []string{"sender@example.com", "sender@example.com", "sender@example.com"},
+			expectedTo:     [][]string{{"form@example.com"}, {"form@example.com"}, {"form@example.com"}},
+		},
+		{
+			name:           "empty_body",
+			body:           func() (string, *bytes.Buffer) { return "message/rfc822", &bytes.Buffer{} },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "multipart_with_empty_file",
+			body:           func() (string, *bytes.Buffer) { return multipartBody(raw, " \r\n\t") },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "multipart_with_empty_file_between_valid_files",
+			body:           func() (string, *bytes.Buffer) { return multipartBody(mbox, "\n", raw) },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "empty_multipart",
+			body:           func() (string, *bytes.Buffer) { return multipartBody() },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid_sender",
+			query:          "?from=nobody",
+			body:           func() (string, *bytes.Buffer) { return "message/rfc822", bytes.NewBufferString(raw) },
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "message_too_large",
+			body:            func() (string, *bytes.Buffer) { return multipartBody(raw, large) },
+			maxMessageBytes: 1024,
+			expectedStatus:  http.StatusRequestEntityTooLarge,
+			expectedError:   "messageb.eml, message 1",
+		},
+		{
+			name:            "mbox_message_too_large",
+			body:            func() (string, *bytes.Buffer) { return "application/mbox", bytes.NewBufferString(mbox) },
+			maxMessageBytes: int64(len(raw)) - 1,
+			expectedStatus:  http.StatusRequestEntityTooLarge,
+			expectedError:   "request body, message 1",
+		},
+		{
+			name:            "within_message_limit",
+			body:            func() (string, *bytes.Buffer) { return "message/rfc822", bytes.NewBufferString(raw) },
+			maxMessageBytes: int64(len(raw)),
+			expectedStatus:  http.StatusCreated,
+			expectedFrom:    []string{"sender@example.com"},
+			expectedTo:      [][]string{{"to@example.com", "copy@example.com"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testBackend := newSMTPBackend()
+			testBackend.maxMessageBytes = tt.maxMessageBytes
+			sharedBackend = testBackend
+
+			contentType, body := tt.body()
+			req := httptest.NewRequest(http.MethodPost, "/messages"+tt.query, body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			handleMessages(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.expectedStatus, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedError) {
+				t.Errorf("error = %s, want it to mention %q", w.Body.String(), tt.expectedError)
+			}
+			if tt.expectedStatus != http.StatusCreated {
+				if testBackend.store.Len() != 0 {
+					t.Errorf("rejected import stored %d messages", testBackend.store.Len())
+				}
+				if events, _ := testBackend.eventsSince(0); len(events) != 0 {
+					t.Errorf("rejected import published %d events", len(events))
+				}
+
+				return
+			}
+
+			var resp importResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if len(resp.Messages) != len(tt.expectedFrom) {
+				t.Fatalf("imported %d messages, want %d", len(resp.Messages), len(tt.expectedFrom))
+			}
+
+			// Stored and announced exactly like a message received over SMTP
+			events, _ := testBackend.eventsSince(0)
+			for i, summary := range resp.Messages {
+				if summary.SMTPFrom != tt.expectedFrom[i] || !slices.Equal(summary.SMTPTo, tt.expectedTo[i]) {
+					t.Errorf("message %d envelope = %s %v, want %s %v", i, summary.SMTPFrom, summary.SMTPTo, tt.expectedFrom[i], tt.expectedTo[i])
+				}
+				view, ok := testBackend.GetByID(summary.ID)
+				if !ok || view.Subject == "" || !strings.HasPrefix(view.ClientAddr, "192.0.2.1") {
+					t.Errorf("message %d stored as %+v", i, view)
+				}
+				if events[i].Name != eventMessageReceived {
+					t.Errorf("event %d = %s, want %s", i, events[i].Name, eventMessageReceived)
+				}
+			}
+		})
+	}
+}
@@ -1,30 +1,159 @@
 package fakesmtpserver
 
 import (
-	"bytes"
-	"encoding/json"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strconv"
 )
 
+// deleteResponse reports how many entries a delete request removed.
+type deleteResponse struct {
+	Deleted int `json:"deleted"`
+}
+
 // registerListHandlers registers all list-related HTTP endpoints.
 func registerListHandlers(mux *http.ServeMux) {
-	mux.HandleFunc("/", handleListAllEmails)
+	mux.HandleFunc("GET /{$}", handleListAllEmails)
+	mux.HandleFunc("/messages", handleMessages)
+	mux.HandleFunc("/messages/{id}", handleMessage)
+	mux.HandleFunc("/messages/{id}/raw", handleRawEmail)
 }
 
-// handleListAllEmails handles the root endpoint that returns all captured emails.
-func handleListAllEmails(w http.ResponseWriter, _ *http.Request) {
-	buf := &bytes.Buffer{}
-	enc := json.NewEncoder(buf)
-	err := enc.Encode(sharedBackend.GetAllData())
+// handleListAllEmails handles the root endpoint that returns a page of captured emails.
+func handleListAllEmails(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	var filter messageFilter
+	if err := parseTimeRange(values, &filter); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	opts, err := parseListOptions(values)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	page, err := sharedBackend.List(filter, opts)
+	if err != nil {
+		slog.Info("list error", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "list failed")
+
+		return
+	}
+
+	writeJSON(w, page)
+}
+
+// handleMessages handles the collection endpoint: GET lists, POST imports and DELETE purges all captured emails.
+func handleMessages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleListAllEmails(w, r)
+	case http.MethodPost:
+		handleImport(w, r)
+	case http.MethodDelete:
+		handleDeleteMessages(w, r)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleDeleteMessages purges the captured emails the list endpoint would return for the same
+// since and until, all of them without a time range.
+func handleDeleteMessages(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	var filter messageFilter
+	if err := parseTimeRange(values, &filter); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+	if err := rejectPagingParameters(values); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	var (
+		n   int
+		err error
+	)
+	if filter.since.IsZero() && filter.until.IsZero() {
+		n, err = sharedBackend.DeleteAll()
+	} else {
+		n, err = sharedBackend.DeleteMatching(filter)
+	}
 	if err != nil {
-		slog.Info("err", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("failed to delete messages", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete messages")
+
+		return
+	}
+
+	slog.Info("deleted messages", "count", n, "since", values.Get("since"), "until", values.Get("until"))
+	writeJSON(w, deleteResponse{Deleted: n})
+}
+
+// handleMessage handles the endpoint that returns or deletes a single captured email by its ID.
+func handleMessage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		view, ok := sharedBackend.GetByID(id)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "message not found")
+
+			return
+		}
+
+		writeJSON(w, view)
+	case http.MethodDelete:
+		ok, err := sharedBackend.DeleteByID(id)
+		if err != nil {
+			slog.Error("failed to delete message", "id", id, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to delete message")
+
+			return
+		}
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "message not found")
+
+			return
+		}
+
+		writeJSON(w, deleteResponse{Deleted: 1})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleRawEmail handles the endpoint that downloads the original DATA payload of a captured email.
+func handleRawEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	id := r.PathValue("id")
+	raw, ok := sharedBackend.GetRawByID(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "message not found")
 
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": id + ".eml"}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(buf.Bytes())
+	_, _ = io.WriteString(w, raw)
 }
@@ -0,0 +1,230 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHandleMessage(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	msgs := setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	wantID := msgs[1].id
+
+	mux := http.NewServeMux()
+	registerListHandlers(mux)
+
+	tests := []struct {
+		name           string
+		method         string
+		id             string
+		expectedStatus int
+	}{
+		{
+			name:           "found",
+			method:         http.MethodGet,
+			id:             wantID,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "not_found",
+			method:         http.MethodGet,
+			id:             "01J00000000000000000000000",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid_method",
+			method:         http.MethodPost,
+			id:             wantID,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/messages/"+tt.id, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("handleMessage() status = %d, want %d", w.Code, tt.expectedStatus)
+			}
+
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("handleMessage() Content-Type = %s, want application/json", contentType)
+			}
+
+			if tt.expectedStatus != http.StatusOK {
+				var errorResp map[string]string
+				if err := json.Unmarshal(w.Body.Bytes(), &errorResp); err != nil {
+					t.Fatalf("Failed to unmarshal error response: %v", err)
+				}
+				if _, exists := errorResp["error"]; !exists {
+					t.Errorf("Error response missing 'error' field")
+				}
+
+				return
+			}
+
+			var view smtpView
+			if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if view.ID != wantID {
+				t.Errorf("handleMessage() id = %s, want %s", view.ID, wantID)
+			}
+			if view.SMTPFrom != "sender2@example.com" {
+				t.Errorf("handleMessage() smtpFrom = %s, want sender2@example.com", view.SMTPFrom)
+			}
+		})
+	}
+}
+
+func TestHandleDeleteEmails(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	msgs := setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	mux := http.NewServeMux()
+	registerListHandlers(mux)
+
+	doDelete := func(t *testing.T, path string, wantStatus, wantDeleted int) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodDelete, path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != wantStatus {
+			t.Fatalf("DELETE %s status = %d, want %d", path, w.Code, wantStatus)
+		}
+		if wantStatus != http.StatusOK {
+			return
+		}
+
+		var resp deleteResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if resp.Deleted != wantDeleted {
+			t.Errorf("DELETE %s deleted = %d, want %d", path, resp.Deleted, wantDeleted)
+		}
+	}
+
+	deletedID := msgs[0].id
+
+	t.Run("delete_by_id", func(t *testing.T) {
+		doDelete(t, "/messages/"+deletedID, http.StatusOK, 1)
+
+		if _, ok := testBackend.GetByID(deletedID); ok {
+			t.Error("message should be gone after delete")
+		}
+		if got := len(testBackend.GetAllData()); got != 2 {
+			t.Errorf("remaining messages = %d, want 2", got)
+		}
+	})
+
+	t.Run("delete_by_id_not_found", func(t *testing.T) {
+		doDelete(t, "/messages/"+deletedID, http.StatusNotFound, 0)
+	})
+
+	t.Run("delete_all_with_paging", func(t *testing.T) {
+		doDelete(t, "/messages?limit=1", http.StatusBadRequest, 0)
+
+		if got := len(testBackend.GetAllData()); got != 2 {
+			t.Errorf("remaining messages = %d, want 2", got)
+		}
+	})
+
+	t.Run("delete_all_time_range", func(t *testing.T) {
+		doDelete(t, "/messages?until="+url.QueryEscape("2000-01-01T00:00:00Z"), http.StatusOK, 0)
+		doDelete(t, "/messages?since=yesterday", http.StatusBadRequest, 0)
+
+		if got := len(testBackend.GetAllData()); got != 2 {
+			t.Errorf("remaining messages = %d, want 2", got)
+		}
+	})
+
+	t.Run("delete_all", func(t *testing.T) {
+		doDelete(t, "/messages", http.StatusOK, 2)
+
+		if got := len(testBackend.GetAllData()); got != 0 {
+			t.Errorf("remaining messages = %d, want 0", got)
+		}
+	})
+
+	t.Run("delete_all_empty", func(t *testing.T) {
+		doDelete(t, "/messages", http.StatusOK, 0)
+	})
+
+	t.Run("invalid_method", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/messages", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("PUT /messages status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestHandleRawEmail(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	msg := setupTestData(t, testBackend)[2]
+	sharedBackend = testBackend
+
+	mux := http.NewServeMux()
+	registerListHandlers(mux)
+
+	t.Run("found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/messages/"+msg.id+"/raw", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("handleRawEmail() status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Type"); got != "message/rfc822" {
+			t.Errorf("handleRawEmail() Content-Type = %s, want message/rfc822", got)
+		}
+		if got, want := w.Header().Get("Content-Disposition"), `attachment; filename=`+msg.id+`.eml`; got != want {
+			t.Errorf("handleRawEmail() Content-Disposition = %s, want %s", got, want)
+		}
+		if w.Body.String() != msg.data {
+			t.Errorf("handleRawEmail() body = %q, want %q", w.Body.String(), msg.data)
+		}
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/messages/unknown/raw", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("handleRawEmail() status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("invalid_method", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/messages/"+msg.id+"/raw", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("handleRawEmail() status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
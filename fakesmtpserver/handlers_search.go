@@ -1,59 +1,145 @@
 package fakesmtpserver
 
 import (
-	"bytes"
-	"encoding/json"
 	"log/slog"
 	"net/http"
+	"net/url"
 )
 
+// queryErrorResponse is the 400 response for a malformed query.
+type queryErrorResponse struct {
+	Error    string `json:"error"`
+	Message  string `json:"message"`  // What is wrong, without the position
+	Token    string `json:"token"`    // Offending token as written, empty at the end of the query
+	Position int    `json:"position"` // Byte offset of the token in the query
+}
+
 // registerSearchHandlers registers all search-related HTTP endpoints.
 func registerSearchHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/search", handleQuerySearch)
 	mux.HandleFunc("/search/to", handleSearchEndpoint(FieldTo))
 	mux.HandleFunc("/search/cc", handleSearchEndpoint(FieldCC))
 	mux.HandleFunc("/search/bcc", handleSearchEndpoint(FieldBCC))
 	mux.HandleFunc("/search/from", handleSearchEndpoint(FieldFrom))
+	mux.HandleFunc("/search/header", handleHeaderSearch)
+	mux.HandleFunc("/search/authuser", handleAuthUserSearch)
 }
 
 // handleSearchEndpoint returns a handler function for the specified search field.
+// GET returns the matching emails and DELETE removes them.
 func handleSearchEndpoint(field string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Only allow GET requests
-		if r.Method != http.MethodGet {
-			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		serveSearch(w, r, func(values url.Values) (messageFilter, error) {
+			email, err := parseEmailParameter(values)
 
-			return
+			return messageFilter{field: field, email: email}, err
+		}, writeParamError)
+	}
+}
+
+// handleQuerySearch handles the endpoint that searches with a query expression, see searchQuery,
+// and a full-text query, see textQueryTokens.
+// GET returns a page of the matching emails and DELETE removes them.
+func handleQuerySearch(w http.ResponseWriter, r *http.Request) {
+	serveSearch(w, r, func(values url.Values) (messageFilter, error) {
+		if values.Get("query") == "" && values.Get("q") == "" {
+			return messageFilter{}, ErrMissingQueryParam
 		}
 
-		// Parse and validate email parameter
-		email, err := parseEmailParameter(r.URL.Query())
-		if err != nil {
+		return parseFilterParameters(values)
+	}, writeBadRequest)
+}
+
+// handleHeaderSearch handles the endpoint that searches by header name and value, see newHeaderFilter.
+// GET returns a page of the matching emails and DELETE removes them.
+func handleHeaderSearch(w http.ResponseWriter, r *http.Request) {
+	serveSearch(w, r, func(values url.Values) (messageFilter, error) {
+		header, err := parseHeaderFilter(values)
+
+		return messageFilter{header: header}, err
+	}, writeParamError)
+}
+
+// serveSearch is the body shared by the search endpoints. parse builds the filter from the query
+// parameters and writeErr answers the request when it fails; since and until narrow it further.
+// GET then returns a page of the matching emails and DELETE removes them.
+func serveSearch(
+	w http.ResponseWriter,
+	r *http.Request,
+	parse func(values url.Values) (messageFilter, error),
+	writeErr func(w http.ResponseWriter, err error),
+) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	values := r.URL.Query()
+	filter, err := parse(values)
+	if err != nil {
+		writeErr(w, err)
+
+		return
+	}
+	if err := parseTimeRange(values, &filter); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := rejectPagingParameters(values); err != nil {
 			writeJSONError(w, http.StatusBadRequest, err.Error())
 
 			return
 		}
 
-		// Perform search
-		results, err := sharedBackend.SearchByField(field, email)
+		n, err := sharedBackend.DeleteMatching(filter)
 		if err != nil {
-			slog.Info("search error", "field", field, "email", email, "error", err)
-			writeJSONError(w, http.StatusInternalServerError, "search failed")
+			slog.Info("delete error", "path", r.URL.Path, "query", r.URL.RawQuery, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "delete failed")
 
 			return
 		}
 
-		// Return results as JSON
-		buf := &bytes.Buffer{}
-		enc := json.NewEncoder(buf)
-		if err := enc.Encode(results); err != nil {
-			slog.Info("encoding error", "error", err)
-			writeJSONError(w, http.StatusInternalServerError, "encoding failed")
+		writeJSON(w, deleteResponse{Deleted: n})
 
-			return
-		}
+		return
+	}
+
+	opts, err := parseListOptions(values)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(buf.Bytes())
+		return
 	}
+
+	page, err := sharedBackend.List(filter, opts)
+	if err != nil {
+		slog.Info("search error", "path", r.URL.Path, "query", r.URL.RawQuery, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "search failed")
+
+		return
+	}
+
+	writeJSON(w, page)
+}
+
+// writeParamError answers a request whose search parameters are missing or malformed.
+func writeParamError(w http.ResponseWriter, err error) {
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
+
+// handleAuthUserSearch handles the endpoint that finds messages by the username their connection authenticated as.
+// GET returns the matching emails and DELETE removes them.
+func handleAuthUserSearch(w http.ResponseWriter, r *http.Request) {
+	serveSearch(w, r, func(values url.Values) (messageFilter, error) {
+		user := values.Get("user")
+		if user == "" {
+			return messageFilter{}, ErrMissingAuthUser
+		}
+
+		return messageFilter{authUser: user}, nil
+	}, writeParamError)
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -16,8 +17,8 @@ func TestHandleSearchEndpoint(t *testing.T) {
 	originalBackend := sharedBackend
 	defer func() { sharedBackend = originalBackend }()
 
-	testBackend := &smtpBackend{}
-	setupTestData(testBackend)
+	testBackend := newSMTPBackend()
+	setupTestData(t, testBackend)
 	sharedBackend = testBackend
 
 	tests := []struct {
@@ -109,13 +110,13 @@ func TestHandleSearchEndpoint(t *testing.T) {
 				}
 
 				// Parse response and check result count
-				var results []smtpView
-				if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				var page messagePage
+				if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
 					t.Fatalf("Failed to unmarshal response: %v", err)
 				}
 
-				if len(results) != tt.expectedCount {
-					t.Errorf("handleSearchEndpoint() returned %d results, want %d. %s", len(results), tt.expectedCount, tt.description)
+				if len(page.Messages) != tt.expectedCount || page.Total != tt.expectedCount {
+					t.Errorf("handleSearchEndpoint() returned %d results (total %d), want %d. %s", len(page.Messages), page.Total, tt.expectedCount, tt.description)
 				}
 			}
 
@@ -195,20 +196,22 @@ func TestSearchEndpointDualSourceLogic(t *testing.T) {
 	originalBackend := sharedBackend
 	defer func() { sharedBackend = originalBackend }()
 
-	testBackend := &smtpBackend{}
+	testBackend := newSMTPBackend()
 
 	// Create a session where SMTP RCPT TO has an email not in headers (BCC scenario)
-	session := &smtpSession{
+	msg := &smtpMessage{
 		data:         createTestEmailData("sender@example.com", "recipient@example.com", "Test Subject"),
 		receivedTime: time.Now(),
 		mailFrom:     "smtp-sender@example.com",                                 // Different from header
 		rcptTo:       []string{"recipient@example.com", "bcc-only@example.com"}, // BCC not in headers
-		clientAddr:   "192.168.1.100:12345",
-		clientHost:   "client.example.com",
-		tlsUsed:      true,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.100:12345",
+			clientHost: "client.example.com",
+			tlsUsed:    true,
+		},
 	}
 
-	testBackend.sessions = []*smtpSession{session}
+	addTestMessage(t, testBackend, msg)
 	sharedBackend = testBackend
 
 	tests := []struct {
@@ -261,12 +264,12 @@ func TestSearchEndpointDualSourceLogic(t *testing.T) {
 				t.Errorf("Expected status 200, got %d", w.Code)
 			}
 
-			var results []smtpView
-			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			var page messagePage
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
 				t.Fatalf("Failed to unmarshal response: %v", err)
 			}
 
-			found := len(results) > 0
+			found := len(page.Messages) > 0
 			if found != tt.expectFound {
 				t.Errorf("%s: found=%v, expectFound=%v. %s", tt.name, found, tt.expectFound, tt.description)
 			}
@@ -274,37 +277,385 @@ func TestSearchEndpointDualSourceLogic(t *testing.T) {
 	}
 }
 
-// Helper function to setup test data.
-func setupTestData(backend *smtpBackend) {
-	session1 := &smtpSession{
+func TestSearchEndpointDelete(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	// recipient1 is only in the first message, bcc@example.com only in the third one
+	tests := []struct {
+		field       string
+		email       string
+		wantDeleted int
+		wantLeft    int
+	}{
+		{field: "to", email: "recipient1@example.com", wantDeleted: 1, wantLeft: 2},
+		{field: "to", email: "recipient1@example.com", wantDeleted: 0, wantLeft: 2},
+		{field: "to", email: "BCC@example.com", wantDeleted: 1, wantLeft: 1},
+		{field: "from", email: "sender2@example.com", wantDeleted: 1, wantLeft: 0},
+	}
+
+	for _, tt := range tests {
+		reqURL := fmt.Sprintf("/search/%s?email=%s", tt.field, url.QueryEscape(tt.email))
+		req := httptest.NewRequest(http.MethodDelete, reqURL, nil)
+		w := httptest.NewRecorder()
+
+		handleSearchEndpoint(tt.field)(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("DELETE %s status = %d, want %d", reqURL, w.Code, http.StatusOK)
+		}
+
+		var resp deleteResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if resp.Deleted != tt.wantDeleted {
+			t.Errorf("DELETE %s deleted = %d, want %d", reqURL, resp.Deleted, tt.wantDeleted)
+		}
+		if got := len(testBackend.GetAllData()); got != tt.wantLeft {
+			t.Errorf("DELETE %s left %d messages, want %d", reqURL, got, tt.wantLeft)
+		}
+	}
+
+	// Missing email must not purge anything, and neither may a page that a delete cannot honor
+	for _, reqURL := range []string{"/search/to", "/search/to?email=recipient3@example.com&limit=1"} {
+		req := httptest.NewRequest(http.MethodDelete, reqURL, nil)
+		w := httptest.NewRecorder()
+		handleSearchEndpoint("to")(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("DELETE %s status = %d, want %d", reqURL, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSearchEndpointDeleteTimeRange(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	setupPagingTestData(t, testBackend, 10)
+	sharedBackend = testBackend
+
+	// The same query string selects the same messages for GET and DELETE
+	query := "?email=odd@example.com&since=" + url.QueryEscape("2026-01-01T00:04:00Z") + "&until=" + url.QueryEscape("2026-01-01T00:08:00Z")
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/search/to"+query, nil)
+		w := httptest.NewRecorder()
+		handleSearchEndpoint(FieldTo)(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status = %d, want %d", method, w.Code, http.StatusOK)
+		}
+
+		if method == http.MethodGet {
+			var page messagePage
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if got := pageIDs(page); !slices.Equal(got, []string{"msg-05", "msg-07"}) {
+				t.Errorf("GET matched %v, want [msg-05 msg-07]", got)
+			}
+
+			continue
+		}
+
+		var resp deleteResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if resp.Deleted != 2 {
+			t.Errorf("DELETE deleted = %d, want 2", resp.Deleted)
+		}
+	}
+
+	if got := len(testBackend.GetAllData()); got != 8 {
+		t.Errorf("DELETE left %d messages, want 8", got)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/search/to?email=odd@example.com&since=yesterday", nil)
+	w := httptest.NewRecorder()
+	handleSearchEndpoint(FieldTo)(w, req)
+	if w.Code != http.StatusBadRequest || len(testBackend.GetAllData()) != 8 {
+		t.Errorf("DELETE with an invalid since = %d, want %d and nothing deleted", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleQuerySearch(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	for _, msg := range queryTestMessages() {
+		addTestMessage(t, testBackend, msg)
+	}
+	sharedBackend = testBackend
+
+	search := func(method, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/search?"+query, nil)
+		w := httptest.NewRecorder()
+		handleQuerySearch(w, req)
+
+		return w
+	}
+
+	w := search(http.MethodGet, "query="+url.QueryEscape(`to:*@example.com -subject:welcome`))
+	var page messagePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if w.Code != http.StatusOK || page.Total != 1 || page.Messages[0].ID != "reset" {
+		t.Errorf("GET status = %d, page = %+v", w.Code, page)
+	}
+
+	// Paging and field filters combine with the query
+	w = search(http.MethodGet, "query=reset&sort=desc&limit=1&field=to&email=carol@example.com")
+	page = messagePage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if page.Total != 1 || page.Messages[0].ID != "welcome" {
+		t.Errorf("GET with field filter page = %+v", page)
+	}
+
+	w = search(http.MethodGet, "query="+url.QueryEscape(`to:a@example.com bogus:1`))
+	var qerr queryErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &qerr); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if w.Code != http.StatusBadRequest || qerr.Token != "bogus:1" || qerr.Position != 17 || qerr.Message != `unknown field "bogus"` {
+		t.Errorf("invalid query status = %d, error = %+v", w.Code, qerr)
+	}
+
+	// Full-text queries are ordered by relevance unless sort is given
+	for query, want := range map[string][]string{
+		"q=reset":           {"reset", "welcome"},
+		"q=reset&sort=desc": {"welcome", "reset"},
+		"q=reset&query=" + url.QueryEscape("has:html OR -to:carol@example.com"): {"reset"},
+		"q=" + url.QueryEscape("Reset password"):                                {"reset"},
+	} {
+		w := search(http.MethodGet, query)
+		page := messagePage{}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		var got []string
+		for _, v := range page.Messages {
+			got = append(got, v.ID)
+		}
+		if w.Code != http.StatusOK || !slices.Equal(got, want) {
+			t.Errorf("GET %s status = %d, messages = %v, want %v", query, w.Code, got, want)
+		}
+	}
+
+	for _, query := range []string{"", "q=" + url.QueryEscape("!!!"), "query=reset&sort=relevance", "q=reset&cursor=" + listCursor{id: "x"}.encode()} {
+		if w := search(http.MethodGet, query); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := search(http.MethodPost, "query=x"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	w = search(http.MethodDelete, "query="+url.QueryEscape(`has:attachment OR from:noreply@shop.example.com`))
+	var resp deleteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.Deleted != 2 || testBackend.store.Len() != 1 {
+		t.Errorf("DELETE deleted %d, left %d messages, want 2 and 1", resp.Deleted, testBackend.store.Len())
+	}
+}
+
+func TestHandleHeaderSearch(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	addTestMessage(t, testBackend, headerTestMessage())
+	for _, msg := range queryTestMessages() {
+		addTestMessage(t, testBackend, msg)
+	}
+	sharedBackend = testBackend
+
+	tests := []struct {
+		query          string
+		method         string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{"name=X-Tenant&value=acme", http.MethodGet, http.StatusOK, []string{"headers", "reset"}},
+		{"name=X-Tenant&value=globex&match=prefix", http.MethodGet, http.StatusOK, []string{"headers", "invoice"}},
+		{"name=X-Tenant&value=" + url.QueryEscape("^glob") + "&match=regex&sort=desc", http.MethodGet, http.StatusOK, []string{"invoice"}},
+		{"name=Received", http.MethodGet, http.StatusOK, []string{"headers"}},
+		{"name=X-Tenant&value=acme&limit=1", http.MethodGet, http.StatusOK, []string{"headers"}},
+		{"value=acme", http.MethodGet, http.StatusBadRequest, nil},
+		{"name=X-Tenant&match=glob", http.MethodGet, http.StatusBadRequest, nil},
+		{"name=X-Tenant&value=(&match=regex", http.MethodGet, http.StatusBadRequest, nil},
+		{"name=X-Tenant", http.MethodPost, http.StatusMethodNotAllowed, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/search/header?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleHeaderSearch(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.expectedStatus, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var page messagePage
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			var got []string
+			for _, v := range page.Messages {
+				got = append(got, v.ID)
+			}
+			if !slices.Equal(got, tt.expectedIDs) {
+				t.Errorf("messages = %v, want %v", got, tt.expectedIDs)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/search/header?name=X-Tenant&value=acme", nil)
+	w := httptest.NewRecorder()
+	handleHeaderSearch(w, req)
+
+	var resp deleteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.Deleted != 2 || testBackend.store.Len() != 2 {
+		t.Errorf("DELETE deleted %d, left %d messages, want 2 and 2", resp.Deleted, testBackend.store.Len())
+	}
+}
+
+// Helper function to setup test data. It returns the stored messages in order.
+func setupTestData(t *testing.T, backend *smtpBackend) []*smtpMessage {
+	t.Helper()
+
+	msg1 := &smtpMessage{
+		id:           newMessageID(),
 		data:         createTestEmailData("sender1@example.com", "recipient1@example.com", "Test Subject 1"),
 		receivedTime: time.Now(),
 		mailFrom:     "sender1@example.com",
 		rcptTo:       []string{"recipient1@example.com", "recipient2@example.com"},
-		clientAddr:   "192.168.1.100:12345",
-		clientHost:   "client1.example.com",
-		tlsUsed:      true,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.100:12345",
+			clientHost: "client1.example.com",
+			tlsUsed:    true,
+		},
 	}
 
-	session2 := &smtpSession{
+	msg2 := &smtpMessage{
+		id:           newMessageID(),
 		data:         createTestEmailData("sender2@example.com", "recipient3@example.com", "Test Subject 2"),
 		receivedTime: time.Now(),
 		mailFrom:     "sender2@example.com",
 		rcptTo:       []string{"recipient3@example.com"},
-		clientAddr:   "192.168.1.101:12346",
-		clientHost:   "client2.example.com",
-		tlsUsed:      false,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.101:12346",
+			clientHost: "client2.example.com",
+			tlsUsed:    false,
+		},
 	}
 
-	session3 := &smtpSession{
+	msg3 := &smtpMessage{
+		id:           newMessageID(),
 		data:         createTestEmailWithCC("sender3@example.com", "recipient4@example.com", "cc@example.com", "Test Subject 3"),
 		receivedTime: time.Now(),
 		mailFrom:     "sender3@example.com",
 		rcptTo:       []string{"recipient4@example.com", "cc@example.com", "bcc@example.com"},
-		clientAddr:   "192.168.1.102:12347",
-		clientHost:   "client3.example.com",
-		tlsUsed:      true,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.102:12347",
+			clientHost: "client3.example.com",
+			tlsUsed:    true,
+		},
 	}
 
-	backend.sessions = []*smtpSession{session1, session2, session3}
+	addTestMessage(t, backend, msg1)
+	addTestMessage(t, backend, msg2)
+	addTestMessage(t, backend, msg3)
+
+	return []*smtpMessage{msg1, msg2, msg3}
+}
+
+func TestHandleAuthUserSearch(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	for i, user := range []string{"svc-billing", "", "svc-orders", "svc-billing"} {
+		msg := &smtpMessage{
+			id:           fmt.Sprintf("m%d", i),
+			data:         createTestEmailData("sender@example.com", "recipient@example.com", "Message"),
+			receivedTime: time.Now(),
+			conn:         &smtpConnection{authenticated: user != "", authUser: user},
+		}
+		addTestMessage(t, testBackend, msg)
+	}
+	sharedBackend = testBackend
+
+	tests := []struct {
+		query          string
+		method         string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{"user=svc-billing", http.MethodGet, http.StatusOK, []string{"m0", "m3"}},
+		{"user=svc-billing&sort=desc&limit=1", http.MethodGet, http.StatusOK, []string{"m3"}},
+		{"user=svc-orders", http.MethodGet, http.StatusOK, []string{"m2"}},
+		{"user=SVC-ORDERS", http.MethodGet, http.StatusOK, nil},
+		{"user=", http.MethodGet, http.StatusBadRequest, nil},
+		{"user=svc-billing&sort=sideways", http.MethodGet, http.StatusBadRequest, nil},
+		{"user=svc-billing", http.MethodPost, http.StatusMethodNotAllowed, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/search/authuser?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleAuthUserSearch(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.expectedStatus, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var page messagePage
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			var got []string
+			for _, v := range page.Messages {
+				got = append(got, v.ID)
+			}
+			if !slices.Equal(got, tt.expectedIDs) {
+				t.Errorf("messages = %v, want %v", got, tt.expectedIDs)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/search/authuser?user=svc-billing", nil)
+	w := httptest.NewRecorder()
+	handleAuthUserSearch(w, req)
+
+	var resp deleteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.Deleted != 2 || testBackend.store.Len() != 2 {
+		t.Errorf("DELETE deleted %d, %d left, want 2 and 2", resp.Deleted, testBackend.store.Len())
+	}
 }
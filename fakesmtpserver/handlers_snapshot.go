@@ -0,0 +1,115 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// maxSnapshotBytes bounds the size of an uploaded snapshot dump.
+const maxSnapshotBytes = 256 << 20
+
+// registerSnapshotHandlers registers all snapshot-related HTTP endpoints.
+func registerSnapshotHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/snapshots", handleSnapshots)
+	mux.HandleFunc("/admin/snapshots/{name}", handleSnapshot)
+	mux.HandleFunc("/admin/snapshots/{name}/restore", handleRestoreSnapshot)
+}
+
+// handleSnapshots handles the endpoint that lists every snapshot.
+func handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	writeJSON(w, sharedBackend.Snapshots())
+}
+
+// handleSnapshot handles a single snapshot: POST saves the current mailbox under the name,
+// GET downloads the snapshot as a dump file, PUT uploads a dump file and DELETE removes it.
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case http.MethodPost:
+		info, err := sharedBackend.CreateSnapshot(name)
+		if err != nil {
+			writeSnapshotError(w, err)
+
+			return
+		}
+
+		writeJSONStatus(w, http.StatusCreated, info)
+	case http.MethodGet:
+		dump, err := sharedBackend.DumpSnapshot(name)
+		if err != nil {
+			writeSnapshotError(w, err)
+
+			return
+		}
+
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".json"}))
+		writeJSON(w, dump)
+	case http.MethodPut:
+		var dump snapshotDump
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotBytes)).Decode(&dump); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid snapshot dump: "+err.Error())
+
+			return
+		}
+
+		info, err := sharedBackend.LoadSnapshot(name, dump)
+		if err != nil {
+			writeSnapshotError(w, err)
+
+			return
+		}
+
+		writeJSONStatus(w, http.StatusCreated, info)
+	case http.MethodDelete:
+		if !sharedBackend.DeleteSnapshot(name) {
+			writeJSONError(w, http.StatusNotFound, "snapshot not found")
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleRestoreSnapshot handles the endpoint that replaces the mailbox with a snapshot.
+func handleRestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	info, err := sharedBackend.RestoreSnapshot(r.PathValue("name"))
+	if err != nil {
+		writeSnapshotError(w, err)
+
+		return
+	}
+
+	slog.Info("restored snapshot", "name", info.Name, "messages", info.Messages)
+	writeJSON(w, info)
+}
+
+func writeSnapshotError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSnapshotNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidSnapshotName), errors.Is(err, ErrDuplicateMessageID):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		slog.Error("snapshot error", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "snapshot failed")
+	}
+}
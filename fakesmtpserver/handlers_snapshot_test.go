@@ -0,0 +1,80 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleSnapshots(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	mux := http.NewServeMux()
+	registerSnapshotHandlers(mux)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		return w
+	}
+
+	if w := do(http.MethodPost, "/admin/snapshots/setup", ""); w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+
+	w := do(http.MethodGet, "/admin/snapshots", "")
+	var infos []snapshotInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil || len(infos) != 1 || infos[0].Messages != 3 {
+		t.Fatalf("list = %s (%v)", w.Body.String(), err)
+	}
+
+	dump := do(http.MethodGet, "/admin/snapshots/setup", "")
+	if dump.Code != http.StatusOK || !strings.Contains(dump.Header().Get("Content-Disposition"), "setup.json") {
+		t.Fatalf("dump status = %d, disposition %q", dump.Code, dump.Header().Get("Content-Disposition"))
+	}
+
+	if w := do(http.MethodPut, "/admin/snapshots/copy", dump.Body.String()); w.Code != http.StatusCreated {
+		t.Fatalf("load status = %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := testBackend.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll() error = %v", err)
+	}
+	if w := do(http.MethodPost, "/admin/snapshots/copy/restore", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore status = %d: %s", w.Code, w.Body.String())
+	}
+	if testBackend.store.Len() != 3 {
+		t.Errorf("restored %d messages, want 3", testBackend.store.Len())
+	}
+
+	if w := do(http.MethodDelete, "/admin/snapshots/copy", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d", w.Code)
+	}
+
+	errorCases := []struct {
+		method, path, body string
+		expectedStatus     int
+	}{
+		{http.MethodPost, "/admin/snapshots/missing/restore", "", http.StatusNotFound},
+		{http.MethodGet, "/admin/snapshots/missing", "", http.StatusNotFound},
+		{http.MethodDelete, "/admin/snapshots/copy", "", http.StatusNotFound},
+		{http.MethodPost, "/admin/snapshots/bad%20name", "", http.StatusBadRequest},
+		{http.MethodPut, "/admin/snapshots/broken", "{", http.StatusBadRequest},
+		{http.MethodGet, "/admin/snapshots/setup/restore", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/snapshots", "", http.StatusMethodNotAllowed},
+	}
+	for _, tc := range errorCases {
+		if w := do(tc.method, tc.path, tc.body); w.Code != tc.expectedStatus {
+			t.Errorf("%s %s status = %d, want %d", tc.method, tc.path, w.Code, tc.expectedStatus)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package fakesmtpserver
+
+import (
+	"net/http"
+)
+
+// registerStatsHandlers registers all stats-related HTTP endpoints.
+func registerStatsHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /stats", handleStats)
+}
+
+// handleStats handles the endpoint that reports mailbox size and retention evictions.
+func handleStats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, sharedBackend.Stats())
+}
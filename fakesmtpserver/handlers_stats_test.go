@@ -0,0 +1,58 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleStats(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	testBackend.retention = retentionPolicy{maxMessages: 2, maxAge: time.Hour}
+	msgs := setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	t.Run("get", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		w := httptest.NewRecorder()
+		handleStats(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("handleStats() status = %d, want %d", w.Code, http.StatusOK)
+		}
+
+		var stats mailboxStats
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+
+		wantBytes := int64(len(msgs[1].data) + len(msgs[2].data))
+		if stats.Messages != 2 || stats.Bytes != wantBytes {
+			t.Errorf("stats = %d messages, %d bytes, want 2, %d", stats.Messages, stats.Bytes, wantBytes)
+		}
+		if stats.Evicted != 1 || stats.EvictedBy[evictReasonMaxMessages] != 1 {
+			t.Errorf("stats evicted = %d %v, want 1 by %s", stats.Evicted, stats.EvictedBy, evictReasonMaxMessages)
+		}
+		if stats.Retention != (retentionStats{MaxMessages: 2, MaxAge: "1h0m0s"}) {
+			t.Errorf("stats retention = %+v", stats.Retention)
+		}
+	})
+
+	t.Run("method_not_allowed", func(t *testing.T) {
+		mux := http.NewServeMux()
+		registerStatsHandlers(mux)
+
+		req := httptest.NewRequest(http.MethodPost, "/stats", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("handleStats() status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
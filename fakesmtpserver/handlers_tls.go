@@ -0,0 +1,29 @@
+package fakesmtpserver
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// registerTLSHandlers registers all TLS-related HTTP endpoints.
+func registerTLSHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /tls/ca.pem", handleTLSCA)
+}
+
+// handleTLSCA handles the endpoint that downloads the CA of the generated SMTP server certificate,
+// so clients can be configured to trust it.
+func handleTLSCA(w http.ResponseWriter, _ *http.Request) {
+	t := sharedBackend.tls
+	if t == nil || t.caPEM == nil {
+		writeJSONError(w, http.StatusNotFound, "no generated certificate authority")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "ca.pem"}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(t.caPEM)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(t.caPEM)
+}
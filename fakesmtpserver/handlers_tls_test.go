@@ -0,0 +1,53 @@
+package fakesmtpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHandleTLSCA(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	sharedBackend = newSMTPBackend()
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleTLSCA(w, httptest.NewRequest(http.MethodGet, "/tls/ca.pem", nil))
+
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Errorf("handleTLSCA() without TLS status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	st, err := newSMTPTLS(testTLSConfig(), time.Now())
+	if err != nil {
+		t.Fatalf("newSMTPTLS() error = %v", err)
+	}
+	sharedBackend.tls = st
+
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("handleTLSCA() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-pem-file" {
+		t.Errorf("Content-Type = %q, want application/x-pem-file", got)
+	}
+	if !slices.Equal(w.Body.Bytes(), st.caPEM) {
+		t.Error("handleTLSCA() body is not the generated CA")
+	}
+
+	mux := http.NewServeMux()
+	registerTLSHandlers(mux)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tls/ca.pem", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handleTLSCA() POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,115 @@
+package fakesmtpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidTimeout is returned when the timeout parameter cannot be parsed.
+	ErrInvalidTimeout = errors.New("invalid timeout")
+	// ErrInvalidCount is returned when the count parameter is not a positive integer.
+	ErrInvalidCount = errors.New("invalid count")
+)
+
+// registerWaitHandlers registers all wait-related HTTP endpoints.
+func registerWaitHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /wait", handleWait)
+}
+
+// handleWait blocks until enough messages match the filter, then returns them.
+// It accepts the filters of the query search, see parseFilterParameters, a timeout and a minimum count.
+// With since only messages received from then on are counted.
+func handleWait(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	filter, err := parseFilterParameters(values)
+	if err != nil {
+		writeBadRequest(w, err)
+
+		return
+	}
+	if err := parseTimeRange(values, &filter); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	timeout, err := parseTimeoutParameter(values)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	count, err := parseCountParameter(values)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	results, err := sharedBackend.WaitForMessages(ctx, filter, count)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		writeJSONError(w, http.StatusRequestTimeout, "timed out waiting for messages")
+	case errors.Is(err, context.Canceled):
+		// The client went away; nobody is left to read a response.
+	case err != nil:
+		slog.Info("wait error", "query", r.URL.RawQuery, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "wait failed")
+	default:
+		writeJSON(w, results)
+	}
+}
+
+// parseTimeoutParameter reads the timeout parameter as a Go duration ("10s") or a number of seconds.
+func parseTimeoutParameter(values url.Values) (time.Duration, error) {
+	v := values.Get("timeout")
+	if v == "" {
+		return defaultWaitTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(v)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			return 0, ErrInvalidTimeout
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	if timeout <= 0 {
+		return 0, ErrInvalidTimeout
+	}
+
+	return min(timeout, maxWaitTimeout), nil
+}
+
+// parseCountParameter reads the minimum number of messages to wait for.
+func parseCountParameter(values url.Values) (int, error) {
+	v := values.Get("count")
+	if v == "" {
+		return 1, nil
+	}
+
+	count, err := strconv.Atoi(v)
+	if err != nil || count < 1 {
+		return 0, ErrInvalidCount
+	}
+
+	return count, nil
+}
@@ -0,0 +1,230 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHandleWait(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:           "already_available",
+			query:          "field=to&email=recipient1@example.com",
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+		},
+		{
+			name:           "no_filter",
+			query:          "count=3",
+			expectedStatus: http.StatusOK,
+			expectedCount:  3,
+		},
+		{
+			name:           "timeout",
+			query:          "field=to&email=nobody@example.com&timeout=50ms",
+			expectedStatus: http.StatusRequestTimeout,
+		},
+		{
+			name:           "timeout_not_enough",
+			query:          "count=4&timeout=1ms",
+			expectedStatus: http.StatusRequestTimeout,
+		},
+		{
+			name:           "invalid_field",
+			query:          "field=subject&email=test@example.com",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "email_without_field",
+			query:          "email=test@example.com",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "header",
+			query:          "name=Subject&value=test+subject+2",
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+		},
+		{
+			name:           "auth_user",
+			query:          "user=nobody&timeout=1ms",
+			expectedStatus: http.StatusRequestTimeout,
+		},
+		{
+			// Every message matches, but none arrived since
+			name:           "since",
+			query:          "since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)) + "&timeout=1ms",
+			expectedStatus: http.StatusRequestTimeout,
+		},
+		{
+			name:           "header_without_name",
+			query:          "value=x",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid_since",
+			query:          "since=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid_timeout",
+			query:          "timeout=soon",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid_count",
+			query:          "count=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/wait?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleWait(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("handleWait() status = %d, want %d: %s", w.Code, tt.expectedStatus, w.Body.String())
+			}
+
+			if tt.expectedStatus != http.StatusOK {
+				var errorResp map[string]string
+				if err := json.Unmarshal(w.Body.Bytes(), &errorResp); err != nil {
+					t.Fatalf("Failed to unmarshal error response: %v", err)
+				}
+
+				return
+			}
+
+			var results []smtpView
+			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if len(results) != tt.expectedCount {
+				t.Errorf("handleWait() returned %d results, want %d", len(results), tt.expectedCount)
+			}
+		})
+	}
+}
+
+func TestHandleWaitMethodNotAllowed(t *testing.T) {
+	mux := http.NewServeMux()
+	registerWaitHandlers(mux)
+
+	req := httptest.NewRequest(http.MethodPost, "/wait", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /wait status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandleWaitWakesOnDelivery(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	sharedBackend = testBackend
+
+	// A message from before the wait does not count towards it
+	since := time.Now().Truncate(time.Second)
+	early := &smtpMessage{
+		id:           newMessageID(),
+		data:         createTestEmailData("sender@example.com", "late@example.com", "Early"),
+		receivedTime: since.Add(-time.Minute),
+		mailFrom:     "sender@example.com",
+		rcptTo:       []string{"late@example.com"},
+		conn:         &smtpConnection{},
+	}
+	addTestMessage(t, testBackend, early)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		query := url.Values{"field": {"to"}, "email": {"late@example.com"}, "count": {"2"}, "timeout": {"10s"}, "since": {since.Format(time.RFC3339)}}
+		req := httptest.NewRequest(http.MethodGet, "/wait?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+		handleWait(w, req)
+		done <- w
+	}()
+
+	start := time.Now()
+	for _, rcpt := range []string{"late@example.com", "other@example.com", "late@example.com"} {
+		time.Sleep(20 * time.Millisecond)
+		addTestMessage(t, testBackend, &smtpMessage{
+			id:           newMessageID(),
+			data:         createTestEmailData("sender@example.com", rcpt, "Late"),
+			receivedTime: time.Now(),
+			mailFrom:     "sender@example.com",
+			rcptTo:       []string{rcpt},
+			conn:         &smtpConnection{},
+		})
+	}
+
+	w := <-done
+	if w.Code != http.StatusOK {
+		t.Fatalf("handleWait() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handleWait() took %s, waiters should wake immediately", elapsed)
+	}
+
+	var results []smtpView
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("handleWait() returned %d results, want 2", len(results))
+	}
+	for _, result := range results {
+		if result.ID == early.id {
+			t.Error("handleWait() counted a message received before since")
+		}
+	}
+}
+
+func TestParseTimeoutParameter(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{query: "", want: defaultWaitTimeout},
+		{query: "timeout=2s", want: 2 * time.Second},
+		{query: "timeout=15", want: 15 * time.Second},
+		{query: "timeout=1h", want: maxWaitTimeout},
+		{query: "timeout=0", wantErr: true},
+		{query: "timeout=-1s", wantErr: true},
+		{query: "timeout=abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		got, err := parseTimeoutParameter(values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeoutParameter(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimeoutParameter(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var (
+	// ErrMissingHeaderName is returned when the name parameter is missing from a header search.
+	ErrMissingHeaderName = errors.New("missing required parameter: name")
+	// ErrInvalidHeaderMatch is returned when the match parameter is not a supported match mode.
+	ErrInvalidHeaderMatch = errors.New("invalid match: must be exact, prefix, substring or regex")
+	// ErrInvalidHeaderRegex is returned when a regex header search has an invalid pattern.
+	ErrInvalidHeaderRegex = errors.New("invalid regex")
+)
+
+const (
+	// Header value match modes of the /search/header endpoint.
+	HeaderMatchExact     = "exact"
+	HeaderMatchPrefix    = "prefix"
+	HeaderMatchSubstring = "substring"
+	HeaderMatchRegex     = "regex"
+)
+
+// headerFilter selects messages with a header called name whose value matches.
+// Every occurrence of a repeated header is tried.
+type headerFilter struct {
+	name  string
+	match func(value string) bool // nil matches any value
+}
+
+// newHeaderFilter returns a filter for header name. An empty value matches any message having the header.
+// Exact, prefix and substring matches ignore case; regular expressions are used as given.
+func newHeaderFilter(name, value, mode string) (*headerFilter, error) {
+	if name == "" {
+		return nil, ErrMissingHeaderName
+	}
+
+	f := &headerFilter{name: name}
+	lower := strings.ToLower(value)
+
+	switch mode {
+	case "", HeaderMatchExact:
+		if value != "" {
+			f.match = func(v string) bool { return strings.EqualFold(v, value) }
+		}
+	case HeaderMatchPrefix:
+		f.match = func(v string) bool { return strings.HasPrefix(strings.ToLower(v), lower) }
+	case HeaderMatchSubstring:
+		f.match = func(v string) bool { return strings.Contains(strings.ToLower(v), lower) }
+	case HeaderMatchRegex:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeaderRegex, err)
+		}
+		f.match = re.MatchString
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidHeaderMatch, mode)
+	}
+
+	return f, nil
+}
+
+// parseHeaderFilter extracts the name, value and match parameters of a header search from query string.
+func parseHeaderFilter(values url.Values) (*headerFilter, error) {
+	return newHeaderFilter(strings.TrimSpace(values.Get("name")), values.Get("value"), values.Get("match"))
+}
+
+// matches reports whether any occurrence of the header in msg matches f.
+func (f *headerFilter) matches(msg *smtpMessage) bool {
+	for _, h := range msg.view.Headers {
+		if strings.EqualFold(h.Key, f.name) && (f.match == nil || f.match(h.Value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseHeaderBlock returns every header field of the message in data in original order,
+// one entry per occurrence. Lines that are neither a field nor a continuation are skipped.
+func parseHeaderBlock(data string) []*smtpViewHeader {
+	headers := []*smtpViewHeader{}
+	var current *smtpViewHeader
+
+	for line := range strings.Lines(data) {
+		content := strings.TrimRight(line, "\r\n")
+		if content == "" {
+			break
+		}
+
+		if content[0] == ' ' || content[0] == '\t' {
+			if current != nil {
+				current.Raw += line
+			}
+
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			current = nil
+
+			continue
+		}
+
+		current = &smtpViewHeader{Key: key, Raw: strings.TrimLeft(raw, " \t")}
+		headers = append(headers, current)
+	}
+
+	for _, h := range headers {
+		h.Raw = strings.TrimRight(h.Raw, "\r\n")
+		h.Value = decodeHeaderValue(h.Raw)
+	}
+
+	return headers
+}
+
+// decodeHeaderValue unfolds raw and decodes its RFC 2047 encoded words.
+// Values that cannot be decoded are returned unfolded.
+func decodeHeaderValue(raw string) string {
+	unfolded := strings.NewReplacer("\r\n", "", "\n", "").Replace(raw)
+	unfolded = strings.TrimSpace(unfolded)
+
+	dec := mime.WordDecoder{CharsetReader: headerCharsetReader}
+	value, err := dec.DecodeHeader(unfolded)
+	if err != nil {
+		return unfolded
+	}
+
+	return value
+}
+
+// headerCharsetReader converts encoded words in any charset known to browsers, such as ISO-2022-JP, to UTF-8.
+func headerCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("header charset: %w", err)
+	}
+
+	return enc.NewDecoder().Reader(input), nil
+}
@@ -0,0 +1,124 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// headerTestMessage returns a parsed message with repeated X-Tenant and Received headers.
+func headerTestMessage() *smtpMessage {
+	msg := &smtpMessage{
+		id: "headers",
+		data: "Received: from a.example.com by b.example.com\r\n" +
+			"Received: from b.example.com by c.example.com\r\n" +
+			"From: sender@example.com\r\n" +
+			"To: recipient@example.com\r\n" +
+			"Subject: Headers\r\n" +
+			"X-Tenant: acme\r\n" +
+			"X-Campaign-ID: spring-2026\r\n" +
+			"X-Tenant: Globex Corp\r\n" +
+			"\r\n" +
+			"Body\r\n",
+		conn: &smtpConnection{},
+	}
+	msg.parse()
+
+	return msg
+}
+
+func TestSMTPViewRepeatedHeaders(t *testing.T) {
+	msg := headerTestMessage()
+
+	keys := make([]string, len(msg.view.Headers))
+	for i, h := range msg.view.Headers {
+		keys[i] = h.Key
+	}
+	want := []string{"Received", "Received", "From", "To", "Subject", "X-Tenant", "X-Campaign-ID", "X-Tenant"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("header keys = %v, want %v", keys, want)
+	}
+	if v := msg.view.Headers[7].Value; v != "Globex Corp" {
+		t.Errorf("second X-Tenant = %q, want Globex Corp", v)
+	}
+}
+
+func TestParseHeaderBlock(t *testing.T) {
+	data := "Received: from a.example.com\r\n" +
+		"\tby b.example.com; Fri, 2 Jan 2026 03:04:05 +0000\r\n" +
+		"Subject: =?UTF-8?B?44GU5rOo5paH?= =?UTF-8?Q?_confirmed?=\r\n" +
+		"X-Japanese: =?ISO-2022-JP?B?GyRCRnxLXDhsGyhC?=\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256;\r\n" +
+		"  b=abc\r\n" +
+		"  def\r\n" +
+		"X-Broken: =?UNKNOWN?Q?x?=\r\n" +
+		"not a header line\r\n" +
+		"X-Empty:\r\n" +
+		"X-Unix: line endings\n" +
+		"\r\n" +
+		"Body-Looking: line\r\n"
+
+	want := []smtpViewHeader{
+		{Key: "Received", Value: "from a.example.com\tby b.example.com; Fri, 2 Jan 2026 03:04:05 +0000", Raw: "from a.example.com\r\n\tby b.example.com; Fri, 2 Jan 2026 03:04:05 +0000"},
+		{Key: "Subject", Value: "ご注文 confirmed", Raw: "=?UTF-8?B?44GU5rOo5paH?= =?UTF-8?Q?_confirmed?="},
+		{Key: "X-Japanese", Value: "日本語", Raw: "=?ISO-2022-JP?B?GyRCRnxLXDhsGyhC?="},
+		{Key: "DKIM-Signature", Value: "v=1; a=rsa-sha256;  b=abc  def", Raw: "v=1; a=rsa-sha256;\r\n  b=abc\r\n  def"},
+		{Key: "X-Broken", Value: "=?UNKNOWN?Q?x?=", Raw: "=?UNKNOWN?Q?x?="},
+		{Key: "X-Empty", Value: "", Raw: ""},
+		{Key: "X-Unix", Value: "line endings", Raw: "line endings"},
+	}
+
+	got := parseHeaderBlock(data)
+	if len(got) != len(want) {
+		t.Fatalf("parseHeaderBlock() returned %d headers, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("header %d = %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+}
+
+func TestHeaderFilter(t *testing.T) {
+	msg := headerTestMessage()
+
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		mode      string
+		wantMatch bool
+		wantErr   error
+	}{
+		{name: "exact", header: "X-Tenant", value: "acme", wantMatch: true},
+		{name: "exact_second_occurrence", header: "x-tenant", value: "globex corp", mode: HeaderMatchExact, wantMatch: true},
+		{name: "exact_partial", header: "X-Tenant", value: "acm", wantMatch: false},
+		{name: "prefix", header: "X-Campaign-ID", value: "SPRING-", mode: HeaderMatchPrefix, wantMatch: true},
+		{name: "prefix_miss", header: "X-Campaign-ID", value: "2026", mode: HeaderMatchPrefix, wantMatch: false},
+		{name: "substring", header: "X-Tenant", value: "obex", mode: HeaderMatchSubstring, wantMatch: true},
+		{name: "regex_repeated", header: "Received", value: `^from b\.`, mode: HeaderMatchRegex, wantMatch: true},
+		{name: "regex_case_sensitive", header: "X-Tenant", value: "^ACME$", mode: HeaderMatchRegex, wantMatch: false},
+		{name: "presence", header: "X-Campaign-ID", wantMatch: true},
+		{name: "absent_header", header: "X-Missing", wantMatch: false},
+		{name: "other_header_value", header: "Subject", value: "acme", wantMatch: false},
+		{name: "address_header", header: "To", value: "recipient@example.com", wantMatch: true},
+		{name: "missing_name", value: "acme", wantErr: ErrMissingHeaderName},
+		{name: "invalid_mode", header: "X-Tenant", value: "acme", mode: "fuzzy", wantErr: ErrInvalidHeaderMatch},
+		{name: "invalid_regex", header: "X-Tenant", value: "(", mode: HeaderMatchRegex, wantErr: ErrInvalidHeaderRegex},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newHeaderFilter(tt.header, tt.value, tt.mode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("newHeaderFilter() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := f.matches(msg); got != tt.wantMatch {
+				t.Errorf("matches() = %v, want %v", got, tt.wantMatch)
+			}
+		})
+	}
+}
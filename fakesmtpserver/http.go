@@ -1,24 +1,31 @@
 package fakesmtpserver
 
 import (
+	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"net/http"
 
 	"github.com/sters/go-fake-smtp-server/config"
 )
 
-// StartViewServer starts the HTTP server that serves captured emails and search endpoints.
-func StartViewServer(cfg *config.Config) error {
-	mux := http.NewServeMux()
+// apiPrefix is where the JSON API is mounted; the web UI owns everything else.
+const apiPrefix = "/api"
 
-	// Register all handlers
-	registerListHandlers(mux)
-	registerSearchHandlers(mux)
+//go:embed ui
+var uiFiles embed.FS
+
+// StartViewServer starts the HTTP server that serves the web UI, captured emails and search endpoints.
+func StartViewServer(cfg *config.Config) error {
+	handler, err := newViewHandler()
+	if err != nil {
+		return err
+	}
 
 	server := &http.Server{
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: cfg.ViewReadHeaderTimeout,
 	}
 
@@ -34,6 +41,34 @@ func StartViewServer(cfg *config.Config) error {
 	return nil
 }
 
+// newViewHandler builds the HTTP handler serving the JSON API under apiPrefix and the web UI at the root.
+func newViewHandler() (http.Handler, error) {
+	api := http.NewServeMux()
+
+	// Register all handlers
+	registerListHandlers(api)
+	registerSearchHandlers(api)
+	registerAttachmentHandlers(api)
+	registerWaitHandlers(api)
+	registerEventHandlers(api)
+	registerStatsHandlers(api)
+	registerExportHandlers(api)
+	registerSnapshotHandlers(api)
+	registerTLSHandlers(api)
+	registerAuthHandlers(api)
+
+	ui, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		return nil, fmt.Errorf("ui files error: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(apiPrefix+"/", http.StripPrefix(apiPrefix, api))
+	mux.Handle("/", http.FileServerFS(ui))
+
+	return mux, nil
+}
+
 func ln(cfg *config.Config) (net.Listener, error) {
 	ln, err := net.Listen("tcp", cfg.ViewAddr)
 	if err != nil {
@@ -1 +1,76 @@
 package fakesmtpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewViewHandler(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	msgs := setupTestData(t, testBackend)
+	sharedBackend = testBackend
+
+	handler, err := newViewHandler()
+	if err != nil {
+		t.Fatalf("newViewHandler() error = %v", err)
+	}
+
+	msgID := msgs[0].id
+
+	tests := []struct {
+		name            string
+		path            string
+		expectedStatus  int
+		expectedType    string
+		expectedContent string
+	}{
+		{name: "ui_index", path: "/", expectedStatus: http.StatusOK, expectedType: "text/html", expectedContent: "<title>go-fake-smtp-server</title>"},
+		{name: "ui_script", path: "/app.js", expectedStatus: http.StatusOK, expectedType: "text/javascript", expectedContent: "EventSource"},
+		{name: "ui_unknown", path: "/unknown.txt", expectedStatus: http.StatusNotFound},
+		{name: "api_root", path: "/api/", expectedStatus: http.StatusOK, expectedType: "application/json", expectedContent: msgID},
+		{name: "api_unknown", path: "/api/unknown", expectedStatus: http.StatusNotFound},
+		{name: "api_list", path: "/api/messages", expectedStatus: http.StatusOK, expectedType: "application/json", expectedContent: msgID},
+		{name: "api_message", path: "/api/messages/" + msgID, expectedStatus: http.StatusOK, expectedType: "application/json", expectedContent: msgID},
+		{name: "api_raw", path: "/api/messages/" + msgID + "/raw", expectedStatus: http.StatusOK, expectedType: "message/rfc822", expectedContent: "Subject: Test Subject 1"},
+		{name: "api_search", path: "/api/search/to?email=recipient1@example.com", expectedStatus: http.StatusOK, expectedType: "application/json", expectedContent: msgID},
+		{name: "api_not_found", path: "/api/messages/unknown", expectedStatus: http.StatusNotFound, expectedType: "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, tt.expectedStatus)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.expectedType) {
+				t.Errorf("GET %s Content-Type = %s, want %s", tt.path, got, tt.expectedType)
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedContent) {
+				t.Errorf("GET %s body does not contain %q", tt.path, tt.expectedContent)
+			}
+		})
+	}
+
+	t.Run("api_delete", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/messages", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var resp deleteResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if resp.Deleted != 3 {
+			t.Errorf("DELETE /api/messages deleted = %d, want 3", resp.Deleted)
+		}
+	})
+}
@@ -1,12 +1,16 @@
 package fakesmtpserver
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -14,6 +18,20 @@ var (
 	ErrMissingEmailParam = errors.New("missing required parameter: email")
 	// ErrInvalidEmailFormat is returned when the email parameter has an invalid format.
 	ErrInvalidEmailFormat = errors.New("invalid email format")
+	// ErrMissingFieldParam is returned when an email filter is given without a field.
+	ErrMissingFieldParam = errors.New("missing required parameter: field")
+	// ErrInvalidLimit is returned when the limit parameter is not a non-negative integer.
+	ErrInvalidLimit = errors.New("invalid limit")
+	// ErrInvalidOffset is returned when the offset parameter is not a non-negative integer.
+	ErrInvalidOffset = errors.New("invalid offset")
+	// ErrInvalidSort is returned when the sort parameter is not asc, desc or relevance.
+	ErrInvalidSort = errors.New("invalid sort: must be asc, desc or relevance")
+	// ErrMissingQueryParam is returned when both the query and q parameters are missing from the request.
+	ErrMissingQueryParam = errors.New("missing required parameter: query or q")
+	// ErrInvalidTime is returned when the since or until parameter is not an RFC 3339 timestamp.
+	ErrInvalidTime = errors.New("invalid time: must be RFC 3339")
+	// ErrPagingOnDelete is returned when a delete request carries paging parameters, which cannot narrow a delete.
+	ErrPagingOnDelete = errors.New("paging parameters are not supported on DELETE")
 )
 
 // parseEmailParameter extracts and validates the email parameter from query string.
@@ -32,6 +50,146 @@ func parseEmailParameter(values url.Values) (string, error) {
 	return email, nil
 }
 
+// parseMessageFilter extracts the optional field, email, query and q parameters from query string.
+// Without any of them every message matches.
+func parseMessageFilter(values url.Values) (messageFilter, error) {
+	var filter messageFilter
+
+	if v := values.Get("q"); v != "" {
+		if len(textQueryTokens(v)) == 0 {
+			return messageFilter{}, ErrInvalidTextQuery
+		}
+		filter.text = v
+	}
+
+	if v := values.Get("query"); v != "" {
+		q, err := parseSearchQuery(v)
+		if err != nil {
+			return messageFilter{}, err
+		}
+		filter.query = q
+	}
+
+	field := values.Get("field")
+	if field == "" {
+		if values.Get("email") != "" {
+			return messageFilter{}, ErrMissingFieldParam
+		}
+
+		return filter, nil
+	}
+
+	if err := validateSearchField(field); err != nil {
+		return messageFilter{}, err
+	}
+
+	email, err := parseEmailParameter(values)
+	if err != nil {
+		return messageFilter{}, err
+	}
+	filter.field, filter.email = field, email
+
+	return filter, nil
+}
+
+// parseFilterParameters extracts the filter shared by the filtered endpoints: the parameters of
+// parseMessageFilter, the name, value and match of a header search and the user of an auth user search.
+// Without any of them every message matches.
+func parseFilterParameters(values url.Values) (messageFilter, error) {
+	filter, err := parseMessageFilter(values)
+	if err != nil {
+		return messageFilter{}, err
+	}
+
+	if values.Has("name") || values.Has("value") || values.Has("match") {
+		header, err := parseHeaderFilter(values)
+		if err != nil {
+			return messageFilter{}, err
+		}
+		filter.header = header
+	}
+
+	filter.authUser = values.Get("user")
+
+	return filter, nil
+}
+
+// parseTimeRange extracts the since and until parameters from query string into filter.
+// They narrow the selected messages, so deletes honor them like searches.
+func parseTimeRange(values url.Values, filter *messageFilter) error {
+	for key, dst := range map[string]*time.Time{"since": &filter.since, "until": &filter.until} {
+		if v := values.Get(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrInvalidTime, key)
+			}
+			*dst = t
+		}
+	}
+
+	return nil
+}
+
+// rejectPagingParameters returns an error if query string carries any parameter of parseListOptions.
+// A delete removes every message its filter selects, so silently ignoring them would delete more than a page.
+func rejectPagingParameters(values url.Values) error {
+	for _, key := range []string{"limit", "offset", "cursor", "sort"} {
+		if values.Has(key) {
+			return fmt.Errorf("%w: %s", ErrPagingOnDelete, key)
+		}
+	}
+
+	return nil
+}
+
+// parseListOptions extracts the limit, offset, cursor and sort parameters from query string.
+// q selects relevance ordering by default.
+func parseListOptions(values url.Values) (listOptions, error) {
+	var opts listOptions
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return listOptions{}, ErrInvalidLimit
+		}
+		opts.limit = n
+	}
+
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return listOptions{}, ErrInvalidOffset
+		}
+		opts.offset = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		if _, err := decodeListCursor(v); err != nil {
+			return listOptions{}, err
+		}
+		opts.cursor = v
+	}
+
+	// Full-text searches are ordered by relevance unless a time order is asked for.
+	switch sort := values.Get("sort"); {
+	case sort == "" && values.Get("q") != "", sort == "relevance":
+		if values.Get("q") == "" {
+			return listOptions{}, ErrRelevanceWithoutText
+		}
+		if opts.cursor != "" {
+			return listOptions{}, ErrRelevanceCursor
+		}
+		opts.relevance = true
+	case sort == "", sort == "asc":
+	case sort == "desc":
+		opts.desc = true
+	default:
+		return listOptions{}, ErrInvalidSort
+	}
+
+	return opts, nil
+}
+
 // writeJSONError writes an error response in JSON format.
 func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -45,3 +203,41 @@ func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
 		slog.Info("failed to encode error response", "error", err)
 	}
 }
+
+// writeBadRequest writes err as a 400 response. Query errors also report the offending token and its position.
+func writeBadRequest(w http.ResponseWriter, err error) {
+	var qerr *queryError
+	if errors.As(err, &qerr) {
+		writeJSONStatus(w, http.StatusBadRequest, queryErrorResponse{
+			Error:    qerr.Error(),
+			Message:  qerr.message,
+			Token:    qerr.token,
+			Position: qerr.pos,
+		})
+
+		return
+	}
+
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
+
+// writeJSON writes v as a successful JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus writes v as a JSON response with the given status code.
+func writeJSONStatus(w http.ResponseWriter, statusCode int, v any) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(v); err != nil {
+		slog.Info("encoding error", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "encoding failed")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(buf.Bytes())
+}
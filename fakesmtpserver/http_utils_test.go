@@ -140,3 +140,55 @@ func TestWriteJSONError(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMessageFilter(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		want        messageFilter
+		expectedErr error
+	}{
+		{
+			name:  "no_filter",
+			query: "",
+			want:  messageFilter{},
+		},
+		{
+			name:  "field_and_email",
+			query: "field=cc&email=cc@example.com",
+			want:  messageFilter{field: FieldCC, email: "cc@example.com"},
+		},
+		{
+			name:        "email_without_field",
+			query:       "email=cc@example.com",
+			expectedErr: ErrMissingFieldParam,
+		},
+		{
+			name:        "field_without_email",
+			query:       "field=to",
+			expectedErr: ErrMissingEmailParam,
+		},
+		{
+			name:        "invalid_field",
+			query:       "field=subject&email=cc@example.com",
+			expectedErr: ErrInvalidSearchField,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Failed to parse query: %v", err)
+			}
+
+			got, err := parseMessageFilter(values)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("parseMessageFilter() error = %v, want %v", err, tt.expectedErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseMessageFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
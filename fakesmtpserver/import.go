@@ -0,0 +1,196 @@
+package fakesmtpserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var (
+	// ErrEmptyMessage is returned when an imported message has no data.
+	ErrEmptyMessage = errors.New("empty message")
+	// ErrInvalidMbox is returned when an mbox file does not start with a "From " line.
+	ErrInvalidMbox = errors.New("invalid mbox: missing From line")
+	// ErrImportTooLarge is returned when an imported message exceeds SMTP_MAX_MESSAGE_BYTES.
+	ErrImportTooLarge = errors.New("message exceeds SMTP_MAX_MESSAGE_BYTES")
+)
+
+type (
+	// importEnvelope is the SMTP envelope of imported messages.
+	// Empty fields are taken from the headers of each message.
+	importEnvelope struct {
+		from string
+		to   []string
+	}
+
+	// importFile is one uploaded file, or the whole body of a request without files.
+	importFile struct {
+		name string // Reported in errors
+		data string
+	}
+)
+
+// importMessages stores every message in files as if it had been received over one new SMTP connection
+// from remoteAddr. Each file is either a single RFC 5322 message or an mbox file.
+// Every file is validated before the first message is stored, so a malformed upload stores nothing.
+func (b *smtpBackend) importMessages(remoteAddr string, env importEnvelope, files []importFile) ([]*smtpMessage, error) {
+	var raws []string
+	for _, f := range files {
+		split, err := splitImport(f.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		for i, raw := range split {
+			if b.maxMessageBytes > 0 && int64(len(raw)) > b.maxMessageBytes {
+				return nil, fmt.Errorf("%w: %s, message %d is %d bytes, limit %d",
+					ErrImportTooLarge, f.name, i+1, len(raw), b.maxMessageBytes)
+			}
+		}
+		raws = append(raws, split...)
+	}
+
+	conn := &smtpConnection{
+		id:            b.connSeq.Add(1),
+		connectedTime: time.Now(),
+		clientAddr:    remoteAddr,
+	}
+
+	messages := make([]*smtpMessage, 0, len(raws))
+	for _, raw := range raws {
+		msg := newImportedMessage(conn, env, raw)
+		if err := b.addMessage(msg); err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+// newImportedMessage builds the transaction smtpSession would have built for raw.
+func newImportedMessage(conn *smtpConnection, env importEnvelope, raw string) *smtpMessage {
+	from, to := env.from, slices.Clone(env.to)
+	if from == "" || len(to) == 0 {
+		headerFrom, headerTo := envelopeFromHeaders(raw)
+		if from == "" {
+			from = headerFrom
+		}
+		if len(to) == 0 {
+			to = headerTo
+		}
+	}
+
+	rcptOpts := make([]*smtp.RcptOptions, len(to))
+	for i := range rcptOpts {
+		rcptOpts[i] = &smtp.RcptOptions{}
+	}
+
+	return &smtpMessage{
+		conn:         conn,
+		data:         raw,
+		receivedTime: time.Now(),
+		mailFrom:     from,
+		mailOpts:     &smtp.MailOptions{},
+		rcptTo:       append(make([]string, 0, len(to)), to...),
+		rcptOpts:     rcptOpts,
+	}
+}
+
+// envelopeFromHeaders returns the first From address and every To, Cc and Bcc address of raw.
+func envelopeFromHeaders(raw string) (string, []string) {
+	m, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return "", nil
+	}
+
+	var from string
+	if list, err := m.Header.AddressList("From"); err == nil && len(list) > 0 {
+		from = list[0].Address
+	}
+
+	var to []string
+	for _, key := range []string{"To", "Cc", "Bcc"} {
+		list, err := m.Header.AddressList(key)
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			to = append(to, addr.Address)
+		}
+	}
+
+	return from, to
+}
+
+// splitImport returns the messages of an uploaded file, which is an mbox if it starts with a "From " line.
+func splitImport(data string) ([]string, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	if strings.HasPrefix(data, "From ") {
+		return splitMbox(strings.NewReader(data))
+	}
+
+	return []string{data}, nil
+}
+
+// splitMbox splits an mboxrd file into its messages, undoing the quoting writeMbox applies.
+func splitMbox(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+
+	var (
+		messages []string
+		current  *strings.Builder
+	)
+	flush := func() {
+		if current != nil {
+			// Drop the blank line that separates messages.
+			msg := current.String()
+			if strings.HasSuffix(strings.TrimSuffix(msg, "\n"), "\n") {
+				msg = strings.TrimSuffix(msg, "\n")
+			}
+			messages = append(messages, msg)
+		}
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			switch {
+			case strings.HasPrefix(line, "From "):
+				flush()
+				current = &strings.Builder{}
+			case current == nil:
+				if strings.TrimSpace(line) != "" {
+					return nil, ErrInvalidMbox
+				}
+			case strings.HasPrefix(line, ">") && isMboxFromLine(line):
+				current.WriteString(line[1:])
+			default:
+				current.WriteString(line)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read mbox: %w", err)
+		}
+	}
+	flush()
+
+	if len(messages) == 0 {
+		return nil, ErrInvalidMbox
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,89 @@
+package fakesmtpserver
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitMboxRoundTrip(t *testing.T) {
+	messages := []*smtpMessage{
+		{mailFrom: "a@example.com", receivedTime: time.Now(), data: "Subject: One\r\n\r\nFrom the start\r\n>From quoted\r\n"},
+		{receivedTime: time.Now(), data: "Subject: Two\n\nplain\n\n"},
+		{receivedTime: time.Now(), data: "Subject: Three\n\nlast\n"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeMbox(&buf, messages); err != nil {
+		t.Fatalf("writeMbox() error = %v", err)
+	}
+
+	got, err := splitMbox(&buf)
+	if err != nil {
+		t.Fatalf("splitMbox() error = %v", err)
+	}
+	if len(got) != len(messages) {
+		t.Fatalf("splitMbox() = %d messages, want %d", len(got), len(messages))
+	}
+	for i, msg := range messages {
+		if got[i] != msg.data {
+			t.Errorf("message %d = %q, want %q", i, got[i], msg.data)
+		}
+	}
+}
+
+func TestSplitImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr error
+	}{
+		{name: "single", data: "Subject: Hi\r\n\r\nbody\r\n", want: 1},
+		{name: "mbox", data: "From a Thu Jan  1 00:00:00 2026\nSubject: 1\n\n\nFrom b Thu Jan  1 00:00:00 2026\nSubject: 2\n\n", want: 2},
+		{name: "empty", data: " \r\n", wantErr: ErrEmptyMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitImport(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("splitImport() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("splitImport() = %d messages, want %d", len(got), tt.want)
+			}
+		})
+	}
+
+	if _, err := splitMbox(strings.NewReader("Subject: not an mbox\n")); !errors.Is(err, ErrInvalidMbox) {
+		t.Errorf("splitMbox() error = %v, want %v", err, ErrInvalidMbox)
+	}
+}
+
+func TestNewImportedMessage(t *testing.T) {
+	raw := "From: Sender <sender@example.com>\r\n" +
+		"To: a@example.com, B <b@example.com>\r\n" +
+		"Cc: c@example.com\r\n" +
+		"Subject: Imported\r\n\r\nbody\r\n"
+	conn := &smtpConnection{id: 1}
+
+	msg := newImportedMessage(conn, importEnvelope{}, raw)
+	if msg.mailFrom != "sender@example.com" {
+		t.Errorf("mailFrom = %q, want the From header address", msg.mailFrom)
+	}
+	if want := []string{"a@example.com", "b@example.com", "c@example.com"}; !slices.Equal(msg.rcptTo, want) {
+		t.Errorf("rcptTo = %v, want %v", msg.rcptTo, want)
+	}
+	if len(msg.rcptOpts) != len(msg.rcptTo) || msg.conn != conn {
+		t.Errorf("unexpected transaction %+v", msg)
+	}
+
+	msg = newImportedMessage(conn, importEnvelope{from: "bounce@example.com", to: []string{"hidden@example.com"}}, raw)
+	if msg.mailFrom != "bounce@example.com" || !slices.Equal(msg.rcptTo, []string{"hidden@example.com"}) {
+		t.Errorf("explicit envelope = %q %v, want bounce@example.com [hidden@example.com]", msg.mailFrom, msg.rcptTo)
+	}
+}
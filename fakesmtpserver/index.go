@@ -0,0 +1,188 @@
+package fakesmtpserver
+
+import (
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+)
+
+// messageIndex keeps stored messages in arrival order together with lookup
+// structures that are updated incrementally on insert and delete, so reads
+// never have to parse or scan message data.
+// It is not safe for concurrent use; memoryStore guards it with its own mux.
+type messageIndex struct {
+	messages []*smtpMessage
+	byID     map[string]*smtpMessage
+	size     int64 // Total bytes of message data
+	// addresses maps a search field and a lowercased address to the messages
+	// containing it, in arrival order.
+	addresses map[string]map[string][]*smtpMessage
+
+	// postings maps a full-text term to the messages containing it and its weighted frequency
+	// in each, see fulltext.go.
+	postings   map[string]map[*smtpMessage]int
+	termCounts map[*smtpMessage]int // Weighted number of terms per message
+	totalTerms int
+}
+
+func newMessageIndex() *messageIndex {
+	return &messageIndex{
+		byID:       make(map[string]*smtpMessage),
+		addresses:  make(map[string]map[string][]*smtpMessage),
+		postings:   make(map[string]map[*smtpMessage]int),
+		termCounts: make(map[*smtpMessage]int),
+	}
+}
+
+// add indexes msg, parsing it first if that has not happened yet.
+func (idx *messageIndex) add(msg *smtpMessage) {
+	msg.parse()
+
+	idx.messages = append(idx.messages, msg)
+	idx.byID[msg.id] = msg
+	idx.size += int64(len(msg.data))
+
+	for _, field := range []string{FieldTo, FieldCC, FieldBCC, FieldFrom} {
+		byAddress, ok := idx.addresses[field]
+		if !ok {
+			byAddress = make(map[string][]*smtpMessage)
+			idx.addresses[field] = byAddress
+		}
+
+		for _, addr := range addressKeys(msg.view, field) {
+			byAddress[addr] = append(byAddress[addr], msg)
+		}
+	}
+
+	idx.addText(msg)
+}
+
+// remove drops the message with the given ID and returns it, or nil if it is not indexed.
+func (idx *messageIndex) remove(id string) *smtpMessage {
+	msg, ok := idx.byID[id]
+	if !ok {
+		return nil
+	}
+
+	delete(idx.byID, id)
+	idx.size -= int64(len(msg.data))
+	idx.messages = deleteMessage(idx.messages, msg)
+
+	for field, byAddress := range idx.addresses {
+		for _, addr := range addressKeys(msg.view, field) {
+			byAddress[addr] = deleteMessage(byAddress[addr], msg)
+			if len(byAddress[addr]) == 0 {
+				delete(byAddress, addr)
+			}
+		}
+	}
+
+	idx.removeText(msg)
+
+	return msg
+}
+
+// get returns the message with the given ID.
+func (idx *messageIndex) get(id string) (*smtpMessage, bool) {
+	msg, ok := idx.byID[id]
+
+	return msg, ok
+}
+
+// oldest returns the first indexed message.
+func (idx *messageIndex) oldest() (*smtpMessage, bool) {
+	if len(idx.messages) == 0 {
+		return nil, false
+	}
+
+	return idx.messages[0], true
+}
+
+// receivedBefore returns the indexed messages received before t, in arrival order.
+// Messages loaded from a maildir or restored from a snapshot can be older than earlier arrivals,
+// so every message is looked at.
+func (idx *messageIndex) receivedBefore(t time.Time) []*smtpMessage {
+	var messages []*smtpMessage
+	for _, msg := range idx.messages {
+		if msg.receivedTime.Before(t) {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages
+}
+
+// all returns a copy of every indexed message in arrival order.
+func (idx *messageIndex) all() []*smtpMessage {
+	return slices.Clone(idx.messages)
+}
+
+// search returns the messages containing email in the given field, in arrival order.
+func (idx *messageIndex) search(field, email string) []*smtpMessage {
+	return slices.Clone(idx.addresses[field][strings.ToLower(email)])
+}
+
+// len returns the number of indexed messages.
+func (idx *messageIndex) len() int {
+	return len(idx.messages)
+}
+
+// bytes returns the total size of the indexed message data.
+func (idx *messageIndex) bytes() int64 {
+	return idx.size
+}
+
+// deleteMessage removes msg from messages. Retention evicts the oldest message,
+// so it is looked for at the front first and dropped without moving the rest.
+func deleteMessage(messages []*smtpMessage, msg *smtpMessage) []*smtpMessage {
+	if len(messages) > 0 && messages[0] == msg {
+		messages[0] = nil
+
+		return messages[1:]
+	}
+
+	i := slices.Index(messages, msg)
+	if i < 0 {
+		return messages
+	}
+
+	return slices.Delete(messages, i, i+1)
+}
+
+// addressKeys returns the distinct lowercased addresses a search on field matches for view.
+func addressKeys(view *smtpView, field string) []string {
+	var keys []string
+
+	switch field {
+	case FieldTo:
+		// Search both To header and SMTP RCPT TO
+		keys = lowerAddresses(view.ToAddressList)
+		for _, email := range view.SMTPTo {
+			keys = append(keys, strings.ToLower(email))
+		}
+	case FieldCC:
+		keys = lowerAddresses(view.CcAddressList)
+	case FieldBCC:
+		// BCC only visible in SMTP transaction, not in headers
+		keys = lowerAddresses(view.BccAddressList)
+	case FieldFrom:
+		// Search both From header and SMTP MAIL FROM
+		keys = append(lowerAddresses(view.FromAddressList), strings.ToLower(view.SMTPFrom))
+	}
+
+	slices.Sort(keys)
+
+	return slices.Compact(keys)
+}
+
+func lowerAddresses(addresses []*mail.Address) []string {
+	keys := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		if addr != nil {
+			keys = append(keys, strings.ToLower(addr.Address))
+		}
+	}
+
+	return keys
+}
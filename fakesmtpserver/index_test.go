@@ -0,0 +1,110 @@
+package fakesmtpserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageIndex(t *testing.T) {
+	idx := newMessageIndex()
+
+	msg1 := &smtpMessage{
+		id:       "msg1",
+		data:     createTestEmailWithCC("Sender@example.com", "to@example.com", "copy@example.com", "First"),
+		mailFrom: "bounce@example.com",
+		rcptTo:   []string{"to@example.com", "hidden@example.com"},
+		conn:     &smtpConnection{},
+	}
+	msg2 := &smtpMessage{
+		id:       "msg2",
+		data:     createTestEmailData("sender@example.com", "other@example.com", "Second"),
+		mailFrom: "sender@example.com",
+		rcptTo:   []string{"other@example.com"},
+		conn:     &smtpConnection{},
+	}
+	idx.add(msg1)
+	idx.add(msg2)
+
+	tests := []struct {
+		field string
+		email string
+		want  []string
+	}{
+		{field: FieldTo, email: "to@example.com", want: []string{"msg1"}},
+		{field: FieldTo, email: "HIDDEN@example.com", want: []string{"msg1"}},
+		{field: FieldCC, email: "copy@example.com", want: []string{"msg1"}},
+		{field: FieldFrom, email: "sender@example.com", want: []string{"msg1", "msg2"}},
+		{field: FieldFrom, email: "bounce@example.com", want: []string{"msg1"}},
+		{field: FieldBCC, email: "hidden@example.com", want: nil},
+	}
+
+	for _, tt := range tests {
+		got := idx.search(tt.field, tt.email)
+		if len(got) != len(tt.want) {
+			t.Errorf("search(%s, %s) = %d messages, want %v", tt.field, tt.email, len(got), tt.want)
+
+			continue
+		}
+		for i, msg := range got {
+			if msg.id != tt.want[i] {
+				t.Errorf("search(%s, %s)[%d] = %s, want %s", tt.field, tt.email, i, msg.id, tt.want[i])
+			}
+		}
+	}
+
+	if removed := idx.remove("msg1"); removed != msg1 {
+		t.Fatalf("remove(msg1) = %v, want msg1", removed)
+	}
+	if removed := idx.remove("msg1"); removed != nil {
+		t.Errorf("remove(msg1) twice = %v, want nil", removed)
+	}
+
+	if got := idx.search(FieldFrom, "sender@example.com"); len(got) != 1 || got[0] != msg2 {
+		t.Errorf("search after remove = %v, want [msg2]", got)
+	}
+	if got := idx.search(FieldTo, "to@example.com"); len(got) != 0 {
+		t.Errorf("search for removed message = %v, want none", got)
+	}
+	if _, ok := idx.addresses[FieldTo]["to@example.com"]; ok {
+		t.Error("empty address entries should be dropped")
+	}
+	if idx.len() != 1 {
+		t.Errorf("len() = %d, want 1", idx.len())
+	}
+}
+
+func TestMessageParsedOnce(t *testing.T) {
+	backend := newSMTPBackend()
+	msg := &smtpMessage{
+		data:         createTestEmailData("sender@example.com", "recipient@example.com", "Parsed once"),
+		receivedTime: time.Now(),
+		conn:         &smtpConnection{},
+	}
+	addTestMessage(t, backend, msg)
+
+	if msg.id == "" {
+		t.Fatal("addMessage() should assign an ID")
+	}
+
+	// The view is built at receive time, later reads must not parse again
+	msg.data = "garbage"
+
+	view, ok := backend.GetByID(msg.id)
+	if !ok {
+		t.Fatal("GetByID() did not find the stored message")
+	}
+	if view.Subject != "Parsed once" {
+		t.Errorf("GetByID() subject = %q, want the subject parsed at receive time", view.Subject)
+	}
+
+	addTestMessage(t, backend, &smtpMessage{data: "not a mail", conn: &smtpConnection{}})
+	results, err := backend.SearchByField(FieldTo, "recipient@example.com")
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchByField() = %d results, %v", len(results), err)
+	}
+
+	all := backend.GetAllData()
+	if all[1].ParseError == "" {
+		t.Error("parse errors should be kept on the view")
+	}
+}
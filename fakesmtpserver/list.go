@@ -0,0 +1,168 @@
+package fakesmtpserver
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrRelevanceWithoutText is returned when relevance ordering is requested without a full-text query.
+	ErrRelevanceWithoutText = errors.New("sort=relevance requires q")
+	// ErrRelevanceCursor is returned when a cursor is combined with relevance ordering.
+	ErrRelevanceCursor = errors.New("cursor is not supported with relevance ordering, use offset")
+)
+
+type (
+	// listOptions controls ordering and paging of List results.
+	listOptions struct {
+		limit     int    // Maximum number of messages to return, 0 for all
+		offset    int    // Number of messages to skip after the cursor
+		cursor    string // nextCursor of the previous page
+		desc      bool   // Newest first
+		relevance bool   // Most relevant first, for full-text searches only; cursors are not supported
+	}
+
+	// messagePage is one page of List results.
+	messagePage struct {
+		Messages   []smtpView `json:"messages"`
+		Total      int        `json:"total"`                // Matching messages across all pages
+		NextCursor string     `json:"nextCursor,omitempty"` // Empty on the last page
+	}
+
+	// listCursor is the sort key of the last message on a page.
+	listCursor struct {
+		receivedTime time.Time
+		id           string
+	}
+)
+
+func (c listCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.receivedTime.UnixNano(), 10) + ":" + c.id))
+}
+
+func decodeListCursor(s string) (listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return listCursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	nanos, id, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return listCursor{}, ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return listCursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	return listCursor{receivedTime: time.Unix(0, n), id: id}, nil
+}
+
+// compareMessages orders messages by receivedTime, then by ID.
+func compareMessages(aTime time.Time, aID string, bTime time.Time, bID string) int {
+	if c := aTime.Compare(bTime); c != 0 {
+		return c
+	}
+
+	return strings.Compare(aID, bID)
+}
+
+// List returns one page of the messages matching filter.
+func (b *smtpBackend) List(filter messageFilter, opts listOptions) (messagePage, error) {
+	messages, page, err := b.listMessages(filter, opts)
+	if err != nil {
+		return messagePage{}, err
+	}
+	page.Messages = messageViews(messages)
+
+	return page, nil
+}
+
+// listMessages returns one page of the stored messages matching filter.
+// The returned messagePage carries the total and cursor but no views.
+func (b *smtpBackend) listMessages(filter messageFilter, opts listOptions) ([]*smtpMessage, messagePage, error) {
+	var after *listCursor
+	if opts.cursor != "" {
+		c, err := decodeListCursor(opts.cursor)
+		if err != nil {
+			return nil, messagePage{}, err
+		}
+		after = &c
+	}
+
+	messages, scores, err := b.matching(filter)
+	if err != nil {
+		return nil, messagePage{}, err
+	}
+
+	if opts.relevance {
+		if scores == nil {
+			return nil, messagePage{}, ErrRelevanceWithoutText
+		}
+		slices.SortStableFunc(messages, func(x, y *smtpMessage) int {
+			return cmp.Compare(scores[y], scores[x])
+		})
+	}
+
+	messages, page := paginate(messages, opts, after)
+
+	return messages, page, nil
+}
+
+// paginate applies the ordering and paging of opts to messages
+// and returns the messages of the page. messages is reordered in place.
+func paginate(messages []*smtpMessage, opts listOptions, after *listCursor) ([]*smtpMessage, messagePage) {
+	page := messagePage{Total: len(messages)}
+
+	// Relevance ordering is applied by the caller and cannot be resumed with a cursor.
+	if opts.relevance {
+		messages = messages[min(opts.offset, len(messages)):]
+		if opts.limit > 0 && len(messages) > opts.limit {
+			messages = messages[:opts.limit]
+		}
+
+		return messages, page
+	}
+
+	// Messages are stored in arrival order, which is almost always already sorted.
+	byTime := func(x, y *smtpMessage) int {
+		return compareMessages(x.receivedTime, x.id, y.receivedTime, y.id)
+	}
+	if !slices.IsSortedFunc(messages, byTime) {
+		slices.SortFunc(messages, byTime)
+	}
+	if opts.desc {
+		slices.Reverse(messages)
+	}
+
+	if after != nil {
+		start := len(messages)
+		for i, msg := range messages {
+			c := compareMessages(msg.receivedTime, msg.id, after.receivedTime, after.id)
+			if (!opts.desc && c > 0) || (opts.desc && c < 0) {
+				start = i
+
+				break
+			}
+		}
+		messages = messages[start:]
+	}
+
+	messages = messages[min(opts.offset, len(messages)):]
+	if opts.limit > 0 && len(messages) > opts.limit {
+		last := messages[opts.limit-1]
+		page.NextCursor = listCursor{receivedTime: last.receivedTime, id: last.id}.encode()
+		messages = messages[:opts.limit]
+	}
+
+	return messages, page
+}
@@ -0,0 +1,212 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// setupPagingTestData stores n messages received one minute apart, alternating recipients.
+func setupPagingTestData(t *testing.T, backend *smtpBackend, n int) time.Time {
+	t.Helper()
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range n {
+		rcpt := "even@example.com"
+		if i%2 == 1 {
+			rcpt = "odd@example.com"
+		}
+		addTestMessage(t, backend, &smtpMessage{
+			id:           fmt.Sprintf("msg-%02d", i),
+			data:         createTestEmailData("sender@example.com", rcpt, fmt.Sprintf("Message %d", i)),
+			receivedTime: base.Add(time.Duration(i) * time.Minute),
+			mailFrom:     "sender@example.com",
+			rcptTo:       []string{rcpt},
+			conn:         &smtpConnection{},
+		})
+	}
+
+	return base
+}
+
+func pageIDs(page messagePage) []string {
+	ids := make([]string, len(page.Messages))
+	for i, m := range page.Messages {
+		ids[i] = m.ID
+	}
+
+	return ids
+}
+
+func TestList(t *testing.T) {
+	backend := newSMTPBackend()
+	base := setupPagingTestData(t, backend, 10)
+
+	tests := []struct {
+		name      string
+		filter    messageFilter
+		opts      listOptions
+		wantIDs   []string
+		wantTotal int
+		wantMore  bool
+	}{
+		{
+			name:      "all",
+			wantIDs:   []string{"msg-00", "msg-01", "msg-02", "msg-03", "msg-04", "msg-05", "msg-06", "msg-07", "msg-08", "msg-09"},
+			wantTotal: 10,
+		},
+		{
+			name:      "limit_offset",
+			opts:      listOptions{limit: 3, offset: 2},
+			wantIDs:   []string{"msg-02", "msg-03", "msg-04"},
+			wantTotal: 10,
+			wantMore:  true,
+		},
+		{
+			name:      "desc",
+			opts:      listOptions{limit: 2, desc: true},
+			wantIDs:   []string{"msg-09", "msg-08"},
+			wantTotal: 10,
+			wantMore:  true,
+		},
+		{
+			name:      "since_until",
+			filter:    messageFilter{since: base.Add(3 * time.Minute), until: base.Add(6 * time.Minute)},
+			wantIDs:   []string{"msg-03", "msg-04", "msg-05"},
+			wantTotal: 3,
+		},
+		{
+			name:      "filtered",
+			filter:    messageFilter{field: FieldTo, email: "odd@example.com"},
+			opts:      listOptions{limit: 4, desc: true},
+			wantIDs:   []string{"msg-09", "msg-07", "msg-05", "msg-03"},
+			wantTotal: 5,
+			wantMore:  true,
+		},
+		{
+			name:      "exact_last_page",
+			opts:      listOptions{limit: 10},
+			wantIDs:   []string{"msg-00", "msg-01", "msg-02", "msg-03", "msg-04", "msg-05", "msg-06", "msg-07", "msg-08", "msg-09"},
+			wantTotal: 10,
+		},
+		{
+			name:      "offset_past_end",
+			opts:      listOptions{offset: 20},
+			wantIDs:   []string{},
+			wantTotal: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := backend.List(tt.filter, tt.opts)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+
+			if got := pageIDs(page); fmt.Sprint(got) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("List() ids = %v, want %v", got, tt.wantIDs)
+			}
+			if page.Total != tt.wantTotal {
+				t.Errorf("List() total = %d, want %d", page.Total, tt.wantTotal)
+			}
+			if (page.NextCursor != "") != tt.wantMore {
+				t.Errorf("List() nextCursor = %q, want more pages: %v", page.NextCursor, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	for _, desc := range []bool{false, true} {
+		backend := newSMTPBackend()
+		setupPagingTestData(t, backend, 7)
+
+		var seen []string
+		opts := listOptions{limit: 3, desc: desc}
+		for {
+			page, err := backend.List(messageFilter{}, opts)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			seen = append(seen, pageIDs(page)...)
+
+			// Deleting the message the cursor points at must not break paging
+			if len(page.Messages) > 0 {
+				if _, err := backend.DeleteByID(page.Messages[len(page.Messages)-1].ID); err != nil {
+					t.Fatalf("DeleteByID() error = %v", err)
+				}
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			opts.cursor = page.NextCursor
+		}
+
+		if len(seen) != 7 || seen[0] == seen[6] {
+			t.Errorf("desc=%v: paged through %v, want all 7 messages once", desc, seen)
+		}
+	}
+
+	backend := newSMTPBackend()
+	if _, err := backend.List(messageFilter{}, listOptions{cursor: "!!!"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("List() with invalid cursor error = %v, want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestHandleListAllEmails(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	testBackend := newSMTPBackend()
+	setupPagingTestData(t, testBackend, 5)
+	sharedBackend = testBackend
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		wantIDs        []string
+	}{
+		{name: "default", query: "", expectedStatus: http.StatusOK, wantIDs: []string{"msg-00", "msg-01", "msg-02", "msg-03", "msg-04"}},
+		{name: "paged", query: "limit=2&offset=1&sort=desc", expectedStatus: http.StatusOK, wantIDs: []string{"msg-03", "msg-02"}},
+		{name: "since", query: "since=" + url.QueryEscape("2026-01-01T00:03:00Z"), expectedStatus: http.StatusOK, wantIDs: []string{"msg-03", "msg-04"}},
+		{name: "invalid_limit", query: "limit=-1", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_offset", query: "offset=x", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_sort", query: "sort=size", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_since", query: "since=yesterday", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_cursor", query: "cursor=abc", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/messages?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleListAllEmails(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("handleListAllEmails() status = %d, want %d", w.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var page messagePage
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if got := pageIDs(page); fmt.Sprint(got) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("handleListAllEmails() ids = %v, want %v", got, tt.wantIDs)
+			}
+			if page.Total != len(tt.wantIDs) && tt.name != "paged" {
+				t.Errorf("handleListAllEmails() total = %d, want %d", page.Total, len(tt.wantIDs))
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package fakesmtpserver
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+const (
+	maildirDirMode  = 0o700
+	maildirFileMode = 0o600
+)
+
+// maildirSink writes every stored message into a Maildir as well, see https://cr.yp.to/proto/maildir.html.
+// Files are named "<unix seconds>.<message ID>.<hostname>" and start with Return-Path and
+// Delivered-To headers carrying the SMTP envelope, like a local delivery agent would add.
+type maildirSink struct {
+	dir      string
+	hostname string
+}
+
+// OpenMaildir attaches the Maildir sink configured by cfg to the shared backend.
+// With cfg.MaildirLoad the messages already in the Maildir are stored first.
+// It must be called after OpenStore and before the servers are started.
+func OpenMaildir(cfg *config.Config) error {
+	if cfg.MaildirPath == "" {
+		return nil
+	}
+
+	sink, err := newMaildirSink(cfg.MaildirPath)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MaildirLoad {
+		if err := sink.loadInto(sharedBackend); err != nil {
+			return err
+		}
+	}
+
+	sharedBackend.maildir = sink
+
+	return nil
+}
+
+// newMaildirSink creates the tmp, new and cur directories below dir if needed.
+func newMaildirSink(dir string) (*maildirSink, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), maildirDirMode); err != nil {
+			return nil, fmt.Errorf("create maildir: %w", err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return &maildirSink{
+		dir: dir,
+		// "/" and ":" cannot appear in a Maildir filename.
+		hostname: strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname),
+	}, nil
+}
+
+func (s *maildirSink) filename(msg *smtpMessage) string {
+	return strconv.FormatInt(msg.receivedTime.Unix(), 10) + "." + msg.id + "." + s.hostname
+}
+
+// write delivers msg into tmp/ and then moves it to new/, so readers never see a partial file.
+func (s *maildirSink) write(msg *smtpMessage) error {
+	name := s.filename(msg)
+	tmp := filepath.Join(s.dir, "tmp", name)
+
+	if err := s.writeFile(tmp, msg); err != nil {
+		_ = os.Remove(tmp)
+
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+
+		return fmt.Errorf("deliver maildir file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *maildirSink) writeFile(path string, msg *smtpMessage) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, maildirFileMode)
+	if err != nil {
+		return fmt.Errorf("create maildir file: %w", err)
+	}
+
+	_, err = f.WriteString(maildirEnvelope(msg) + msg.data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write maildir file: %w", err)
+	}
+
+	// The modification time carries the received time back on load.
+	if err := os.Chtimes(path, msg.receivedTime, msg.receivedTime); err != nil {
+		return fmt.Errorf("set maildir file time: %w", err)
+	}
+
+	return nil
+}
+
+// maildirEnvelope returns the trace headers recording the SMTP envelope of msg,
+// using the line ending of the message itself.
+func maildirEnvelope(msg *smtpMessage) string {
+	eol := "\n"
+	if strings.Contains(msg.data, "\r\n") {
+		eol = "\r\n"
+	}
+
+	var b strings.Builder
+	b.WriteString("Return-Path: <" + msg.mailFrom + ">" + eol)
+	for _, rcpt := range msg.rcptTo {
+		b.WriteString("Delivered-To: " + rcpt + eol)
+	}
+
+	return b.String()
+}
+
+// loadInto stores every message found in new/ and cur/, oldest first.
+// Messages whose ID is already stored are skipped.
+func (s *maildirSink) loadInto(b *smtpBackend) error {
+	var messages []*smtpMessage
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(s.dir, sub))
+		if err != nil {
+			return fmt.Errorf("read maildir: %w", err)
+		}
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			msg, err := readMaildirMessage(filepath.Join(s.dir, sub, entry.Name()))
+			if err != nil {
+				return err
+			}
+			messages = append(messages, msg)
+		}
+	}
+
+	slices.SortFunc(messages, func(x, y *smtpMessage) int {
+		return cmp.Or(x.receivedTime.Compare(y.receivedTime), strings.Compare(x.id, y.id))
+	})
+
+	loaded := 0
+	for _, msg := range messages {
+		if err := b.addMessage(msg); err != nil {
+			if errors.Is(err, ErrDuplicateMessageID) {
+				continue
+			}
+
+			return fmt.Errorf("load maildir: %w", err)
+		}
+		loaded++
+	}
+	slog.Info("loaded maildir", "dir", s.dir, "messages", loaded)
+
+	return nil
+}
+
+// readMaildirMessage reads one Maildir file back into a message.
+// The ID and the envelope headers are taken from the file when it is one of ours. Files of other
+// sources keep their data unchanged and are assigned a new ID.
+func readMaildirMessage(path string) (*smtpMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read maildir file: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat maildir file: %w", err)
+	}
+
+	msg := &smtpMessage{
+		data:         string(data),
+		receivedTime: info.ModTime(),
+		rcptTo:       make([]string, 0),
+		conn:         &smtpConnection{},
+	}
+
+	id, ours := maildirMessageID(filepath.Base(path))
+	if !ours {
+		msg.id = newMessageID()
+
+		return msg, nil
+	}
+	msg.id = id
+	msg.data = parseMaildirEnvelope(msg, msg.data)
+
+	return msg, nil
+}
+
+// maildirMessageID extracts the message ID from a filename written by maildirSink
+// and reports whether name is one, see maildirSink.
+func maildirMessageID(name string) (string, bool) {
+	name, _, _ = strings.Cut(name, ":")
+	parts := strings.Split(name, ".")
+	if len(parts) < 3 {
+		return "", false
+	}
+	if _, err := strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return "", false
+	}
+	if _, err := ulid.ParseStrict(parts[1]); err != nil {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+// parseMaildirEnvelope moves the leading Return-Path and Delivered-To headers of data
+// into the envelope of msg and returns the rest of data.
+func parseMaildirEnvelope(msg *smtpMessage, data string) string {
+	r := bufio.NewReader(strings.NewReader(data))
+	rest := data
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return rest
+		}
+
+		key, value, ok := strings.Cut(strings.TrimRight(line, "\r\n"), ":")
+		if !ok {
+			return rest
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(key) {
+		case "return-path":
+			msg.mailFrom = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+		case "delivered-to":
+			msg.rcptTo = append(msg.rcptTo, value)
+		default:
+			return rest
+		}
+		rest = rest[len(line):]
+	}
+}
+
+// deliverToMaildir writes msg to the Maildir sink if one is attached. Failures are logged only,
+// as the message is already stored.
+func (b *smtpBackend) deliverToMaildir(msg *smtpMessage) {
+	if b.maildir == nil {
+		return
+	}
+
+	if err := b.maildir.write(msg); err != nil {
+		slog.Error("failed to write maildir", "id", msg.id, "error", err)
+	}
+}
@@ -0,0 +1,162 @@
+package fakesmtpserver
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+func TestMaildirSinkWrite(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newMaildirSink(dir)
+	if err != nil {
+		t.Fatalf("newMaildirSink() error = %v", err)
+	}
+
+	backend := newSMTPBackend()
+	backend.maildir = sink
+
+	msg := &smtpMessage{
+		id:           newMessageID(),
+		data:         createTestEmailData("sender@example.com", "recipient@example.com", "Maildir"),
+		receivedTime: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		mailFrom:     "bounce@example.com",
+		rcptTo:       []string{"recipient@example.com", "hidden@example.com"},
+		conn:         &smtpConnection{},
+	}
+	addTestMessage(t, backend, msg)
+
+	if entries, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(entries) != 0 {
+		t.Errorf("tmp/ should be empty after delivery, got %d entries", len(entries))
+	}
+
+	path := filepath.Join(dir, "new", sink.filename(msg))
+	if !strings.Contains(filepath.Base(path), msg.id) {
+		t.Errorf("filename %q does not contain the message ID", filepath.Base(path))
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("message was not delivered to new/: %v", err)
+	}
+	want := "Return-Path: <bounce@example.com>\r\n" +
+		"Delivered-To: recipient@example.com\r\n" +
+		"Delivered-To: hidden@example.com\r\n" +
+		msg.data
+	if string(b) != want {
+		t.Errorf("maildir file = %q, want %q", b, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat() error = %v", err)
+	}
+	if !info.ModTime().Equal(msg.receivedTime) {
+		t.Errorf("maildir file time = %v, want %v", info.ModTime(), msg.receivedTime)
+	}
+}
+
+func TestMaildirLoad(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newMaildirSink(dir)
+	if err != nil {
+		t.Fatalf("newMaildirSink() error = %v", err)
+	}
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	rcpts := []string{"first@example.com", "second@example.com"}
+	written := make([]*smtpMessage, 0, len(rcpts))
+	for i, rcpt := range rcpts {
+		msg := &smtpMessage{
+			id:           newMessageID(),
+			data:         createTestEmailData("sender@example.com", rcpt, "Stored"),
+			receivedTime: base.Add(time.Duration(i) * time.Minute),
+			mailFrom:     "sender@example.com",
+			rcptTo:       []string{rcpt},
+			conn:         &smtpConnection{},
+		}
+		if err := sink.write(msg); err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+		written = append(written, msg)
+	}
+
+	// A message delivered by another tool and already seen by a mail client
+	foreign := filepath.Join(dir, "cur", "1700000000.M1P2.otherhost:2,S")
+	foreignTime := base.Add(-time.Hour)
+	// Its envelope headers are not ours to interpret and stay in the data
+	foreignData := "Return-Path: <bounce@other.example>\nDelivered-To: someone@example.com\nSubject: Foreign\n\nHello\n"
+	if err := os.WriteFile(foreign, []byte(foreignData), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	if err := os.Chtimes(foreign, foreignTime, foreignTime); err != nil {
+		t.Fatalf("os.Chtimes() error = %v", err)
+	}
+
+	backend := newSMTPBackend()
+	if err := sink.loadInto(backend); err != nil {
+		t.Fatalf("loadInto() error = %v", err)
+	}
+
+	messages := backend.store.List()
+	if len(messages) != 3 {
+		t.Fatalf("loaded %d messages, want 3", len(messages))
+	}
+
+	if messages[0].view.Subject != "Foreign" || !messages[0].receivedTime.Equal(foreignTime) || messages[0].data != foreignData {
+		t.Errorf("foreign message = %q at %v", messages[0].data, messages[0].receivedTime)
+	}
+	if messages[0].mailFrom != "" || len(messages[0].rcptTo) != 0 {
+		t.Errorf("foreign envelope = %s %v, want none", messages[0].mailFrom, messages[0].rcptTo)
+	}
+	if messages[0].id == "M1P2" || messages[0].id == "" {
+		t.Errorf("foreign message ID = %q, want a newly assigned one", messages[0].id)
+	}
+
+	for i, want := range written {
+		got := messages[i+1]
+		if got.id != want.id || got.data != want.data || !got.receivedTime.Equal(want.receivedTime) {
+			t.Errorf("loaded message %d = %s %q at %v, want %s %q at %v",
+				i, got.id, got.data, got.receivedTime, want.id, want.data, want.receivedTime)
+		}
+		if got.mailFrom != want.mailFrom || !slices.Equal(got.rcptTo, want.rcptTo) {
+			t.Errorf("loaded envelope = %s %v, want %s %v", got.mailFrom, got.rcptTo, want.mailFrom, want.rcptTo)
+		}
+	}
+
+	// Loading again skips the messages with known IDs
+	if err := sink.loadInto(backend); err != nil {
+		t.Fatalf("second loadInto() error = %v", err)
+	}
+	if n := backend.store.Len(); n != 4 {
+		t.Errorf("stored %d messages after loading twice, want 4 (only the foreign one again)", n)
+	}
+}
+
+func TestOpenMaildir(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	sharedBackend = newSMTPBackend()
+	if err := OpenMaildir(&config.Config{}); err != nil || sharedBackend.maildir != nil {
+		t.Fatalf("OpenMaildir() without a path = %v, sink %v", err, sharedBackend.maildir)
+	}
+
+	dir := filepath.Join(t.TempDir(), "mail")
+	if err := OpenMaildir(&config.Config{MaildirPath: dir, MaildirLoad: true}); err != nil {
+		t.Fatalf("OpenMaildir() error = %v", err)
+	}
+	if sharedBackend.maildir == nil {
+		t.Fatal("OpenMaildir() did not attach the sink")
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			t.Errorf("%s/ was not created: %v", sub, err)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery is wrapped by every error returned for a malformed search query.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// queryDateLayout is the layout of dates in after:, before: and on: terms.
+const queryDateLayout = "2006-01-02"
+
+type (
+	// searchQuery is a parsed query expression such as
+	//
+	//	to:alice@example.com subject:"reset password" after:2026-01-01 has:attachment -from:noreply@example.com
+	//
+	// Terms are ANDed unless joined by OR; "-" or NOT negates a term or a parenthesized group.
+	// See newQueryTermMatcher for the supported fields.
+	searchQuery struct {
+		match queryMatcher
+	}
+
+	// queryMatcher reports whether a message matches a query or part of it.
+	queryMatcher func(msg *smtpMessage) bool
+
+	// queryError describes why a query could not be parsed and where.
+	queryError struct {
+		message string
+		token   string // Offending token as written, empty at the end of the query
+		pos     int    // Byte offset of token in the query
+	}
+
+	queryTokenKind int
+
+	// queryToken is a lexical element of a query.
+	queryToken struct {
+		kind  queryTokenKind
+		text  string // As written in the query
+		pos   int    // Byte offset in the query
+		field string // Lowercased field of a term, empty for free text
+		value string // Value of a term without quotes
+		regex bool   // Value was written as /regex/
+	}
+
+	queryParser struct {
+		tokens []queryToken
+		next   int
+	}
+)
+
+const (
+	queryTokenTerm queryTokenKind = iota
+	queryTokenOr
+	queryTokenAnd
+	queryTokenNot
+	queryTokenOpen
+	queryTokenClose
+	queryTokenEnd
+)
+
+func (e *queryError) Error() string {
+	if e.token == "" {
+		return fmt.Sprintf("%s: %s at position %d", ErrInvalidQuery, e.message, e.pos)
+	}
+
+	return fmt.Sprintf("%s: %s at position %d: %q", ErrInvalidQuery, e.message, e.pos, e.token)
+}
+
+func (e *queryError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+func newQueryError(tok queryToken, format string, args ...any) *queryError {
+	return &queryError{message: fmt.Sprintf(format, args...), token: tok.text, pos: tok.pos}
+}
+
+// parseSearchQuery parses a query expression. Errors are *queryError values pointing at the bad token.
+func parseSearchQuery(query string) (*searchQuery, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	if p.peek().kind == queryTokenEnd {
+		return nil, newQueryError(p.peek(), "empty query")
+	}
+
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	// parseAnd consumes everything but OR, which parseOr consumes, so only ")" can be left.
+	if tok := p.peek(); tok.kind != queryTokenEnd {
+		return nil, newQueryError(tok, "unexpected closing parenthesis")
+	}
+
+	return &searchQuery{match: match}, nil
+}
+
+// matches reports whether msg matches q.
+func (q *searchQuery) matches(msg *smtpMessage) bool {
+	return q.match(msg)
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) take() queryToken {
+	tok := p.tokens[p.next]
+	if tok.kind != queryTokenEnd {
+		p.next++
+	}
+
+	return tok
+}
+
+// parseOr parses terms joined by OR, which binds weaker than AND.
+func (p *queryParser) parseOr() (queryMatcher, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	matchers := []queryMatcher{first}
+	for p.peek().kind == queryTokenOr {
+		p.take()
+		m, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	if len(matchers) == 1 {
+		return first, nil
+	}
+
+	return func(msg *smtpMessage) bool {
+		for _, m := range matchers {
+			if m(msg) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// parseAnd parses a sequence of terms, optionally joined by AND.
+func (p *queryParser) parseAnd() (queryMatcher, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	matchers := []queryMatcher{first}
+	for {
+		switch p.peek().kind {
+		case queryTokenAnd:
+			p.take()
+		case queryTokenTerm, queryTokenNot, queryTokenOpen:
+		case queryTokenOr, queryTokenClose, queryTokenEnd:
+			if len(matchers) == 1 {
+				return first, nil
+			}
+
+			return func(msg *smtpMessage) bool {
+				for _, m := range matchers {
+					if !m(msg) {
+						return false
+					}
+				}
+
+				return true
+			}, nil
+		}
+
+		m, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+}
+
+// parseUnary parses a term or group, optionally negated.
+func (p *queryParser) parseUnary() (queryMatcher, error) {
+	if p.peek().kind == queryTokenNot {
+		p.take()
+		m, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(msg *smtpMessage) bool { return !m(msg) }, nil
+	}
+
+	tok := p.take()
+	switch tok.kind {
+	case queryTokenTerm:
+		return newQueryTermMatcher(tok)
+	case queryTokenOpen:
+		m, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.take().kind != queryTokenClose {
+			return nil, newQueryError(tok, "missing closing parenthesis")
+		}
+
+		return m, nil
+	case queryTokenClose:
+		return nil, newQueryError(tok, "unexpected closing parenthesis")
+	case queryTokenOr, queryTokenAnd, queryTokenNot:
+		return nil, newQueryError(tok, "expected a search term before %s", tok.text)
+	case queryTokenEnd:
+	}
+
+	return nil, newQueryError(tok, "expected a search term")
+}
+
+// lexQuery splits query into tokens, ending with a queryTokenEnd token.
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+
+	i := 0
+	for {
+		for i < len(query) && isQuerySpace(query[i]) {
+			i++
+		}
+		if i == len(query) {
+			return append(tokens, queryToken{kind: queryTokenEnd, pos: i}), nil
+		}
+
+		switch c := query[i]; {
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: queryTokenOpen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: queryTokenClose, text: ")", pos: i})
+			i++
+		case c == '-' && i+1 < len(query) && !isQuerySpace(query[i+1]) && query[i+1] != ')':
+			tokens = append(tokens, queryToken{kind: queryTokenNot, text: "-", pos: i})
+			i++
+		default:
+			tok, err := lexQueryTerm(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i += len(tok.text)
+		}
+	}
+}
+
+// lexQueryTerm reads the term starting at query[start]: an optional "field:" prefix followed by
+// a word, "quoted text" or a /regex/. Quoted parts may appear anywhere in a word.
+func lexQueryTerm(query string, start int) (queryToken, error) {
+	tok := queryToken{kind: queryTokenTerm, pos: start}
+
+	i := start
+	for i < len(query) && isQueryFieldChar(query[i]) {
+		i++
+	}
+	if i > start && i < len(query) && query[i] == ':' {
+		tok.field = strings.ToLower(query[start:i])
+		i++
+	} else {
+		i = start
+	}
+
+	if i < len(query) && query[i] == '/' {
+		value, end, ok := scanQueryQuoted(query, i, '/')
+		if !ok {
+			return queryToken{}, &queryError{message: "unterminated regular expression", token: query[start:], pos: start}
+		}
+		if end < len(query) && !isQueryTermEnd(query[end]) {
+			return queryToken{}, &queryError{message: "unexpected text after regular expression", token: query[start:], pos: start}
+		}
+		tok.text, tok.value, tok.regex = query[start:end], value, true
+
+		return tok, nil
+	}
+
+	var (
+		value  strings.Builder
+		quoted bool
+	)
+	for i < len(query) && !isQueryTermEnd(query[i]) {
+		if query[i] != '"' {
+			value.WriteByte(query[i])
+			i++
+
+			continue
+		}
+
+		s, end, ok := scanQueryQuoted(query, i, '"')
+		if !ok {
+			return queryToken{}, &queryError{message: "unterminated quote", token: query[i:], pos: i}
+		}
+		value.WriteString(s)
+		quoted = true
+		i = end
+	}
+	tok.text, tok.value = query[start:i], value.String()
+
+	if tok.field == "" && !quoted {
+		switch tok.value {
+		case "OR":
+			tok.kind = queryTokenOr
+		case "AND":
+			tok.kind = queryTokenAnd
+		case "NOT":
+			tok.kind = queryTokenNot
+		}
+	}
+
+	return tok, nil
+}
+
+// scanQueryQuoted reads the text between the delimiter at query[start] and the next unescaped one.
+// A backslash escapes the delimiter; other escapes are kept as written, which regular expressions rely on.
+// It returns the text and the offset after the closing delimiter.
+func scanQueryQuoted(query string, start int, delim byte) (string, int, bool) {
+	var b strings.Builder
+	for i := start + 1; i < len(query); i++ {
+		switch {
+		case query[i] == '\\' && i+1 < len(query) && query[i+1] == delim:
+			b.WriteByte(delim)
+			i++
+		case query[i] == delim:
+			return b.String(), i + 1, true
+		default:
+			b.WriteByte(query[i])
+		}
+	}
+
+	return "", 0, false
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isQueryTermEnd(c byte) bool {
+	return isQuerySpace(c) || c == '(' || c == ')'
+}
+
+func isQueryFieldChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+}
+
+// newQueryTermMatcher returns the matcher of a single term. Supported terms are
+//
+//	word, "some words"       subject or body contains the text
+//	to:, cc:, bcc:, from:    an address equals the value, see addressKeys
+//	subject:, body:          subject or text and HTML body contains the value
+//	header.<name>:           a header called name contains the value
+//	after:, before:, on:     received at or after, before or on a date (UTC) or RFC 3339 time
+//	has:attachment, has:html the message has attachments or an HTML body
+//
+// Text values may use the * and ? wildcards, which match the whole value, or be a /regex/.
+// Every match is case-insensitive. An address value starting with "@" matches the domain.
+func newQueryTermMatcher(tok queryToken) (queryMatcher, error) {
+	if tok.value == "" && !tok.regex {
+		return nil, newQueryError(tok, "missing value")
+	}
+
+	switch field := tok.field; {
+	case field == "":
+		return newQueryTextMatcher(tok, func(v *smtpView) []string { return []string{v.Subject, v.Text, v.HTML} })
+	case field == "subject":
+		return newQueryTextMatcher(tok, func(v *smtpView) []string { return []string{v.Subject} })
+	case field == "body":
+		return newQueryTextMatcher(tok, func(v *smtpView) []string { return []string{v.Text, v.HTML} })
+	case field == FieldTo, field == FieldCC, field == FieldBCC, field == FieldFrom:
+		return newQueryAddressMatcher(tok)
+	case strings.HasPrefix(field, "header."):
+		return newQueryHeaderMatcher(tok)
+	case field == "after", field == "before", field == "on":
+		return newQueryDateMatcher(tok)
+	case field == "has":
+		return newQueryHasMatcher(tok)
+	default:
+		return nil, newQueryError(tok, "unknown field %q", field)
+	}
+}
+
+func newQueryTextMatcher(tok queryToken, texts func(v *smtpView) []string) (queryMatcher, error) {
+	match, err := newQueryValueMatcher(tok, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg *smtpMessage) bool {
+		for _, s := range texts(msg.view) {
+			if match(s) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func newQueryAddressMatcher(tok queryToken) (queryMatcher, error) {
+	if !tok.regex && strings.HasPrefix(tok.value, "@") {
+		tok.value = "*" + tok.value
+	}
+
+	match, err := newQueryValueMatcher(tok, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg *smtpMessage) bool {
+		for _, addr := range addressKeys(msg.view, tok.field) {
+			if match(addr) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func newQueryHeaderMatcher(tok queryToken) (queryMatcher, error) {
+	name := strings.TrimPrefix(tok.field, "header.")
+	if name == "" {
+		return nil, newQueryError(tok, "missing header name")
+	}
+
+	match, err := newQueryValueMatcher(tok, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg *smtpMessage) bool {
+		for _, h := range msg.view.Headers {
+			if strings.EqualFold(h.Key, name) && match(h.Value) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func newQueryDateMatcher(tok queryToken) (queryMatcher, error) {
+	if tok.regex {
+		return nil, newQueryError(tok, "%s: expects a date", tok.field)
+	}
+
+	t, err := time.Parse(queryDateLayout, tok.value)
+	isDate := err == nil
+	if !isDate {
+		t, err = time.Parse(time.RFC3339, tok.value)
+	}
+	if err != nil || (tok.field == "on" && !isDate) {
+		return nil, newQueryError(tok, "%s: expects a date such as %s", tok.field, queryDateLayout)
+	}
+
+	switch tok.field {
+	case "after":
+		return func(msg *smtpMessage) bool { return !msg.receivedTime.Before(t) }, nil
+	case "before":
+		return func(msg *smtpMessage) bool { return msg.receivedTime.Before(t) }, nil
+	default:
+		end := t.AddDate(0, 0, 1)
+
+		return func(msg *smtpMessage) bool {
+			return !msg.receivedTime.Before(t) && msg.receivedTime.Before(end)
+		}, nil
+	}
+}
+
+func newQueryHasMatcher(tok queryToken) (queryMatcher, error) {
+	switch strings.ToLower(tok.value) {
+	case "attachment":
+		if !tok.regex {
+			return func(msg *smtpMessage) bool { return len(msg.view.Attachments) > 0 }, nil
+		}
+	case "html":
+		if !tok.regex {
+			return func(msg *smtpMessage) bool { return msg.view.HTML != "" }, nil
+		}
+	}
+
+	return nil, newQueryError(tok, "has: expects attachment or html")
+}
+
+// newQueryValueMatcher returns a case-insensitive matcher for the value of tok: a regular expression,
+// a wildcard pattern, or otherwise an exact match if whole is set and a substring match if not.
+func newQueryValueMatcher(tok queryToken, whole bool) (func(s string) bool, error) {
+	switch {
+	case tok.regex:
+		re, err := regexp.Compile("(?i)" + tok.value)
+		if err != nil {
+			return nil, newQueryError(tok, "invalid regular expression: %v", err)
+		}
+
+		return re.MatchString, nil
+	case strings.ContainsAny(tok.value, "*?"):
+		return globRegexp(tok.value).MatchString, nil
+	case whole:
+		return func(s string) bool { return strings.EqualFold(s, tok.value) }, nil
+	default:
+		value := strings.ToLower(tok.value)
+
+		return func(s string) bool { return strings.Contains(strings.ToLower(s), value) }, nil
+	}
+}
+
+// globRegexp compiles a pattern where * matches any text and ? any single character.
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?is)^`)
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`$`)
+
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,163 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// queryTestMessages returns the messages the query tests run against, keyed by ID.
+func queryTestMessages() []*smtpMessage {
+	base := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	newMsg := func(id string, day int, from, to, data string) *smtpMessage {
+		msg := &smtpMessage{
+			id:           id,
+			data:         data,
+			receivedTime: base.AddDate(0, 0, day),
+			mailFrom:     from,
+			rcptTo:       []string{to},
+			conn:         &smtpConnection{},
+		}
+		msg.parse()
+
+		return msg
+	}
+
+	return []*smtpMessage{
+		newMsg("reset", 0, "noreply@shop.example.com", "alice@example.com",
+			"From: noreply@shop.example.com\r\nTo: alice@example.com\r\nSubject: Reset password\r\n"+
+				"X-Tenant: acme\r\n\r\nYour order 12345 code: ABC-987\r\n"),
+		newMsg("invoice", 1, "billing@example.com", "bob@corp.test",
+			"From: billing@example.com\r\nTo: bob@corp.test\r\nCc: alice@example.com\r\nSubject: Invoice 42\r\n"+
+				"X-Tenant: globex\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n"+
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>See attached</p>\r\n"+
+				"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice.pdf\r\n\r\n%PDF\r\n"+
+				"--b--\r\n"),
+		newMsg("welcome", 2, "hello@example.com", "carol@example.com",
+			"From: hello@example.com\r\nTo: carol@example.com\r\nSubject: Welcome aboard\r\n\r\nReset nothing, just welcome.\r\n"),
+	}
+}
+
+func TestSearchQueryMatch(t *testing.T) {
+	messages := queryTestMessages()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`to:alice@example.com`, []string{"reset"}},
+		{`TO:ALICE@EXAMPLE.COM`, []string{"reset"}},
+		{`cc:alice@example.com`, []string{"invoice"}},
+		{`to:*@example.com`, []string{"reset", "welcome"}},
+		{`from:@example.com`, []string{"invoice", "welcome"}},
+		{`from:*@*.example.com`, []string{"reset"}},
+		{`to:/^(bob|carol)@/`, []string{"invoice", "welcome"}},
+		{`subject:"reset password"`, []string{"reset"}},
+		{`subject:invoice*`, []string{"invoice"}},
+		{`subject:"Invoice ??"`, []string{"invoice"}},
+		{`reset`, []string{"reset", "welcome"}},
+		{`"order 12345"`, []string{"reset"}},
+		{`body:/code: [A-Z]+-\d+/`, []string{"reset"}},
+		{`body:attached`, []string{"invoice"}},
+		{`header.X-Tenant:acme`, []string{"reset"}},
+		{`header.x-tenant:*`, []string{"reset", "invoice"}},
+		{`has:attachment`, []string{"invoice"}},
+		{`has:html`, []string{"invoice"}},
+		{`after:2026-01-11`, []string{"invoice", "welcome"}},
+		{`before:2026-01-11`, []string{"reset"}},
+		{`on:2026-01-11`, []string{"invoice"}},
+		{`after:2026-01-11T12:00:01Z`, []string{"welcome"}},
+		{`-from:noreply@shop.example.com`, []string{"invoice", "welcome"}},
+		{`NOT has:attachment reset`, []string{"reset", "welcome"}},
+		{`to:alice@example.com OR to:carol@example.com`, []string{"reset", "welcome"}},
+		{`reset AND subject:welcome`, []string{"welcome"}},
+		{`reset subject:password OR has:attachment`, []string{"reset", "invoice"}},
+		{`-(reset OR invoice)`, nil},
+		{`to:*@example.com -(subject:welcome)`, []string{"reset"}},
+		{`to:alice@example.com subject:"reset password" after:2026-01-01 -from:billing@example.com`, []string{"reset"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := parseSearchQuery(tt.query)
+			if err != nil {
+				t.Fatalf("parseSearchQuery() error = %v", err)
+			}
+
+			var got []string
+			for _, msg := range messages {
+				if q.matches(msg) {
+					got = append(got, msg.id)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		token   string
+		pos     int
+		message string
+	}{
+		{``, "", 0, "empty query"},
+		{`   `, "", 3, "empty query"},
+		{`to:a@example.com size:10`, "size:10", 17, `unknown field "size"`},
+		{`subject:"reset`, `"reset`, 8, "unterminated quote"},
+		{`body:/abc`, "body:/abc", 0, "unterminated regular expression"},
+		{`body:/a/b`, "body:/a/b", 0, "unexpected text after regular expression"},
+		{`body:/(/`, "body:/(/", 0, "invalid regular expression: error parsing regexp: missing closing ): `(?i)(`"},
+		{`after:yesterday`, "after:yesterday", 0, "after: expects a date such as 2006-01-02"},
+		{`on:2026-01-01T00:00:00Z`, "on:2026-01-01T00:00:00Z", 0, "on: expects a date such as 2006-01-02"},
+		{`has:pdf`, "has:pdf", 0, "has: expects attachment or html"},
+		{`to:`, "to:", 0, "missing value"},
+		{`header.:x`, "header.:x", 0, "missing header name"},
+		{`(a OR b`, "(", 0, "missing closing parenthesis"},
+		{`a)`, ")", 1, "unexpected closing parenthesis"},
+		{`a OR`, "", 4, "expected a search term"},
+		{`OR a`, "OR", 0, "expected a search term before OR"},
+		{`a AND OR b`, "OR", 6, "expected a search term before OR"},
+		{`()`, ")", 1, "unexpected closing parenthesis"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := parseSearchQuery(tt.query)
+			if !errors.Is(err, ErrInvalidQuery) {
+				t.Fatalf("parseSearchQuery() error = %v, want %v", err, ErrInvalidQuery)
+			}
+
+			var qerr *queryError
+			if !errors.As(err, &qerr) {
+				t.Fatalf("parseSearchQuery() error %T is not a *queryError", err)
+			}
+			if qerr.token != tt.token || qerr.pos != tt.pos || qerr.message != tt.message {
+				t.Errorf("error = %q at %d (%s), want %q at %d (%s)", qerr.token, qerr.pos, qerr.message, tt.token, tt.pos, tt.message)
+			}
+		})
+	}
+}
+
+func TestLexQueryEscapes(t *testing.T) {
+	tokens, err := lexQuery(`subject:"say \"hi\"" body:/a\/b/ "OR" -x`)
+	if err != nil {
+		t.Fatalf("lexQuery() error = %v", err)
+	}
+
+	want := []queryToken{
+		{kind: queryTokenTerm, text: `subject:"say \"hi\""`, pos: 0, field: "subject", value: `say "hi"`},
+		{kind: queryTokenTerm, text: `body:/a\/b/`, pos: 21, field: "body", value: "a/b", regex: true},
+		{kind: queryTokenTerm, text: `"OR"`, pos: 33, value: "OR"},
+		{kind: queryTokenNot, text: "-", pos: 38},
+		{kind: queryTokenTerm, text: "x", pos: 39, value: "x"},
+		{kind: queryTokenEnd, pos: 40},
+	}
+	if !slices.Equal(tokens, want) {
+		t.Errorf("lexQuery() =\n%+v\nwant\n%+v", tokens, want)
+	}
+}
@@ -0,0 +1,163 @@
+package fakesmtpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+const (
+	// Eviction reasons reported in logs and stats.
+	evictReasonMaxMessages = "maxMessages"
+	evictReasonMaxBytes    = "maxBytes"
+	evictReasonMaxAge      = "maxAge"
+)
+
+type (
+	// retentionPolicy bounds what the backend keeps. A zero limit is disabled.
+	retentionPolicy struct {
+		maxMessages int
+		maxBytes    int64
+		maxAge      time.Duration
+	}
+
+	// mailboxStats is the response of the stats endpoint.
+	mailboxStats struct {
+		Messages  int               `json:"messages"`  // Stored messages
+		Bytes     int64             `json:"bytes"`     // Stored message data in bytes
+		Evicted   uint64            `json:"evicted"`   // Messages evicted by retention since start
+		EvictedBy map[string]uint64 `json:"evictedBy"` // Evictions per reason
+		Retention retentionStats    `json:"retention"`
+	}
+
+	retentionStats struct {
+		MaxMessages int    `json:"maxMessages"`
+		MaxBytes    int64  `json:"maxBytes"`
+		MaxAge      string `json:"maxAge"`
+	}
+)
+
+func newRetentionPolicy(cfg *config.Config) retentionPolicy {
+	return retentionPolicy{
+		maxMessages: cfg.RetentionMaxMessages,
+		maxBytes:    cfg.RetentionMaxBytes,
+		maxAge:      cfg.RetentionMaxAge,
+	}
+}
+
+func (p retentionPolicy) enabled() bool {
+	return p.maxMessages > 0 || p.maxBytes > 0 || p.maxAge > 0
+}
+
+// StartRetentionSweeper evicts messages past the retention limits of cfg every
+// cfg.RetentionSweepInterval until ctx is done. Limits are also enforced on every insert.
+func StartRetentionSweeper(ctx context.Context, cfg *config.Config) error {
+	if cfg.RetentionSweepInterval <= 0 || !newRetentionPolicy(cfg).enabled() {
+		return nil
+	}
+
+	ticker := time.NewTicker(cfg.RetentionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := sharedBackend.sweep(time.Now()); err != nil {
+				slog.Error("retention sweep failed", "error", err)
+			}
+		}
+	}
+}
+
+// sweep evicts every message past the retention limits.
+func (b *smtpBackend) sweep(now time.Time) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.enforceRetentionLocked(now)
+}
+
+// enforceRetentionLocked evicts every message past the maximum age, then the oldest
+// messages until the count and size limits hold. b.mux must be held for writing.
+func (b *smtpBackend) enforceRetentionLocked(now time.Time) error {
+	p := b.retention
+	if !p.enabled() {
+		return nil
+	}
+
+	// Receive times need not follow arrival order, so the age limit looks at every message.
+	if p.maxAge > 0 {
+		for _, msg := range b.store.ReceivedBefore(now.Add(-p.maxAge)) {
+			if err := b.evictLocked(msg, evictReasonMaxAge); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		msg, ok := b.store.Oldest()
+		if !ok {
+			return nil
+		}
+
+		var reason string
+		switch {
+		case p.maxMessages > 0 && b.store.Len() > p.maxMessages:
+			reason = evictReasonMaxMessages
+		case p.maxBytes > 0 && b.store.Size() > p.maxBytes:
+			reason = evictReasonMaxBytes
+		default:
+			return nil
+		}
+
+		if err := b.evictLocked(msg, reason); err != nil {
+			return err
+		}
+	}
+}
+
+// evictLocked removes msg for the given retention reason. b.mux must be held for writing.
+func (b *smtpBackend) evictLocked(msg *smtpMessage, reason string) error {
+	// b.mux is held, so nothing else removes msg in between.
+	if _, err := b.store.Delete(msg.id); err != nil {
+		return fmt.Errorf("evict message %s: %w", msg.id, err)
+	}
+
+	if b.evicted == nil {
+		b.evicted = make(map[string]uint64)
+	}
+	b.evicted[reason]++
+
+	slog.Info("evicted message", "id", msg.id, "reason", reason, "receivedTime", msg.receivedTime)
+	b.publishLocked(eventMessageDeleted, deletedEventData{ID: msg.id})
+
+	return nil
+}
+
+// Stats returns the current size of the mailbox and the retention counters.
+func (b *smtpBackend) Stats() mailboxStats {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	stats := mailboxStats{
+		Messages:  b.store.Len(),
+		Bytes:     b.store.Size(),
+		EvictedBy: make(map[string]uint64, len(b.evicted)),
+		Retention: retentionStats{
+			MaxMessages: b.retention.maxMessages,
+			MaxBytes:    b.retention.maxBytes,
+			MaxAge:      b.retention.maxAge.String(),
+		},
+	}
+	for reason, n := range b.evicted {
+		stats.Evicted += n
+		stats.EvictedBy[reason] = n
+	}
+
+	return stats
+}
@@ -0,0 +1,123 @@
+package fakesmtpserver
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionOnInsert(t *testing.T) {
+	// The age limit is checked against the current time on insert
+	base := time.Now().Add(-3 * time.Minute)
+	newMessage := func(i, bodySize int) *smtpMessage {
+		return &smtpMessage{
+			id:           fmt.Sprintf("msg-%02d", i),
+			data:         "Subject: Retention\r\n\r\n" + strings.Repeat("x", bodySize),
+			receivedTime: base.Add(time.Duration(i) * time.Minute),
+			conn:         &smtpConnection{},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		policy      retentionPolicy
+		sizes       []int
+		wantIDs     []string
+		wantEvicted map[string]uint64
+	}{
+		{
+			name:        "unlimited",
+			sizes:       []int{10, 10, 10},
+			wantIDs:     []string{"msg-00", "msg-01", "msg-02"},
+			wantEvicted: map[string]uint64{},
+		},
+		{
+			name:        "max_messages",
+			policy:      retentionPolicy{maxMessages: 2},
+			sizes:       []int{10, 10, 10, 10},
+			wantIDs:     []string{"msg-02", "msg-03"},
+			wantEvicted: map[string]uint64{evictReasonMaxMessages: 2},
+		},
+		{
+			// Each message is 22 bytes of headers plus its body
+			name:        "max_bytes",
+			policy:      retentionPolicy{maxBytes: 100},
+			sizes:       []int{10, 10, 30, 20},
+			wantIDs:     []string{"msg-02", "msg-03"},
+			wantEvicted: map[string]uint64{evictReasonMaxBytes: 2},
+		},
+		{
+			name:        "max_age",
+			policy:      retentionPolicy{maxAge: 90 * time.Second},
+			sizes:       []int{10, 10, 10, 10},
+			wantIDs:     []string{"msg-02", "msg-03"},
+			wantEvicted: map[string]uint64{evictReasonMaxAge: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newSMTPBackend()
+			backend.retention = tt.policy
+			for i, size := range tt.sizes {
+				addTestMessage(t, backend, newMessage(i, size))
+			}
+
+			if got := storeMessageIDs(backend.store.List()); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("stored messages = %v, want %v", got, tt.wantIDs)
+			}
+
+			stats := backend.Stats()
+			if stats.Messages != len(tt.wantIDs) {
+				t.Errorf("Stats().Messages = %d, want %d", stats.Messages, len(tt.wantIDs))
+			}
+			var wantTotal uint64
+			for reason, n := range tt.wantEvicted {
+				wantTotal += n
+				if stats.EvictedBy[reason] != n {
+					t.Errorf("Stats().EvictedBy[%s] = %d, want %d", reason, stats.EvictedBy[reason], n)
+				}
+			}
+			if stats.Evicted != wantTotal {
+				t.Errorf("Stats().Evicted = %d, want %d", stats.Evicted, wantTotal)
+			}
+		})
+	}
+}
+
+func TestRetentionSweep(t *testing.T) {
+	backend := newSMTPBackend()
+	backend.retention = retentionPolicy{maxAge: time.Hour}
+
+	now := time.Now()
+	for i, age := range []time.Duration{3 * time.Hour, 30 * time.Minute, 2 * time.Hour, time.Minute} {
+		addTestMessage(t, backend, &smtpMessage{
+			id:           fmt.Sprintf("msg-%02d", i),
+			data:         createTestEmailData("sender@example.com", "recipient@example.com", "Sweep"),
+			receivedTime: now.Add(-age),
+			conn:         &smtpConnection{},
+		})
+	}
+
+	// Inserting evicts every expired message, also one received after a newer arrival
+	if got := storeMessageIDs(backend.store.List()); !slices.Equal(got, []string{"msg-01", "msg-03"}) {
+		t.Fatalf("stored messages after insert = %v, want [msg-01 msg-03]", got)
+	}
+
+	ch := backend.store.Subscribe()
+	if err := backend.sweep(now.Add(45 * time.Minute)); err != nil {
+		t.Fatalf("sweep() error = %v", err)
+	}
+
+	if got := storeMessageIDs(backend.store.List()); !slices.Equal(got, []string{"msg-03"}) {
+		t.Errorf("stored messages after sweep = %v, want [msg-03]", got)
+	}
+	assertStoreNotified(t, ch, true)
+
+	events, _ := backend.eventsSince(backend.lastEventID() - 1)
+	if len(events) != 1 || events[0].Name != eventMessageDeleted {
+		t.Errorf("last event = %+v, want a %s event", events, eventMessageDeleted)
+	}
+}
@@ -1,17 +1,22 @@
 package fakesmtpserver
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/mail"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emersion/go-smtp"
 	"github.com/jhillyerd/enmime"
+	"github.com/oklog/ulid/v2"
 	"github.com/sters/go-fake-smtp-server/config"
 )
 
@@ -26,119 +31,284 @@ const (
 	FieldFrom = "from"
 )
 
+const (
+	// Listener names recorded on every connection.
+	ListenerSMTP  = "smtp"  // Plaintext, optionally upgraded with STARTTLS
+	ListenerSMTPS = "smtps" // Implicit TLS
+)
+
 type (
 	smtpView struct {
+		ID      string `json:"id"`      // Unique message ID (ULID)
+		Subject string `json:"subject"` // Decoded Subject header
+
 		// Email Content (parsed from data via enmime)
-		Headers         []*smtpViewHeader `json:"headers"`
-		FromAddressList []*mail.Address   `json:"from"` // From header
-		ToAddressList   []*mail.Address   `json:"to"`   // To header
-		CcAddressList   []*mail.Address   `json:"cc"`   // CC header
-		BccAddressList  []*mail.Address   `json:"bcc"`  // BCC header
-		Text            string            `json:"text"`
-		HTML            string            `json:"html"`
+		Headers         []*smtpViewHeader     `json:"headers"` // Every header field in original order
+		FromAddressList []*mail.Address       `json:"from"`    // From header
+		ToAddressList   []*mail.Address       `json:"to"`      // To header
+		CcAddressList   []*mail.Address       `json:"cc"`      // CC header
+		BccAddressList  []*mail.Address       `json:"bcc"`     // BCC header
+		Text            string                `json:"text"`
+		HTML            string                `json:"html"`
+		Attachments     []*smtpViewAttachment `json:"attachments"`          // Attachments, inlines and other parts
+		ParseError      string                `json:"parseError,omitempty"` // Set when data could not be parsed
 
 		// SMTP Transaction Data (from session)
 		SMTPFrom     string    `json:"smtpFrom"`     // MAIL FROM address
 		SMTPTo       []string  `json:"smtpTo"`       // RCPT TO addresses
-		ReceivedTime time.Time `json:"receivedTime"` // Session timestamp
+		ReceivedTime time.Time `json:"receivedTime"` // DATA completion timestamp
 
 		// Connection Metadata
-		ClientAddr string `json:"clientAddr"` // Remote IP
-		ClientHost string `json:"clientHost"` // HELO/EHLO hostname
-		TLSUsed    bool   `json:"tlsUsed"`    // TLS connection
-
-		// Authentication (if implemented)
-		Authenticated bool   `json:"authenticated"` // Auth success
-		AuthMechanism string `json:"authMechanism"` // PLAIN, LOGIN, etc.
+		ConnectionID     uint64 `json:"connectionId"`               // Connection the message was received on
+		Listener         string `json:"listener"`                   // smtp or smtps
+		ClientAddr       string `json:"clientAddr"`                 // Remote IP
+		ClientHost       string `json:"clientHost"`                 // HELO/EHLO hostname
+		TLSUsed          bool   `json:"tlsUsed"`                    // TLS connection
+		TLSVersion       string `json:"tlsVersion,omitempty"`       // Negotiated protocol version, e.g. TLS 1.3
+		TLSCipherSuite   string `json:"tlsCipherSuite,omitempty"`   // Negotiated cipher suite
+		TLSServerName    string `json:"tlsServerName,omitempty"`    // SNI sent by the client
+		TLSClientSubject string `json:"tlsClientSubject,omitempty"` // Subject of the client certificate
+
+		// Authentication
+		Authenticated bool   `json:"authenticated"`          // Auth success
+		AuthMechanism string `json:"authMechanism"`          // PLAIN, LOGIN, etc.
+		AuthUser      string `json:"authUser,omitempty"`     // Authenticated username
+		AuthPassword  string `json:"authPassword,omitempty"` // Redacted or hashed password, see SMTP_AUTH_CAPTURE_PASSWORD
 	}
 
+	// smtpViewHeader is one header field occurrence, see parseHeaderBlock.
 	smtpViewHeader struct {
-		Key   string `json:"key"`
-		Value string `json:"value"`
+		Key   string `json:"key"`   // Field name as written
+		Value string `json:"value"` // Unfolded and RFC 2047-decoded
+		Raw   string `json:"raw"`   // As written after the colon, including folding
 	}
 )
 
 type smtpBackend struct {
-	sessions []*smtpSession
-	mux      sync.RWMutex
+	store Store
+	// mux serializes store updates with the event journal.
+	mux sync.RWMutex
+
+	// events is the journal of recent mailbox events, see events.go.
+	events   []mailboxEvent
+	eventSeq uint64
+
+	// retention limits the stored messages, see retention.go.
+	retention retentionPolicy
+	evicted   map[string]uint64 // Evictions per reason
+
+	// maxMessageBytes limits imported messages like SMTP_MAX_MESSAGE_BYTES limits received ones, 0 for none.
+	maxMessageBytes int64
+
+	// maildir receives a copy of every stored message when set, see maildir.go.
+	maildir *maildirSink
+
+	// tls enables STARTTLS when set, see tls.go.
+	tls *smtpTLS
+	// tlsPolicy decides which connections may send mail, see tlspolicy.go.
+	tlsPolicy tlsPolicy
+
+	// auth offers AUTH when it has credentials, see auth.go.
+	auth         smtpAuth
+	authFailures []authFailure // Most recent failed logins
+
+	// snapshots are the named copies of the mailbox, see snapshot.go.
+	snapshots map[string]*mailboxSnapshot
+
+	connSeq atomic.Uint64
 }
 
-var sharedBackend = &smtpBackend{} //nolint:gochecknoglobals
+var sharedBackend = newSMTPBackend() //nolint:gochecknoglobals
+
+func newSMTPBackend() *smtpBackend {
+	return newSMTPBackendWithStore(newMemoryStore())
+}
+
+// newSMTPBackendWithStore returns a backend using store, numbering new connections after the stored ones.
+func newSMTPBackendWithStore(store Store) *smtpBackend {
+	b := &smtpBackend{store: store}
+	for _, msg := range store.List() {
+		if msg.conn.id > b.connSeq.Load() {
+			b.connSeq.Store(msg.conn.id)
+		}
+	}
+
+	return b
+}
 
 func (b *smtpBackend) NewSession(conn *smtp.Conn) (smtp.Session, error) {
-	slog.Info("NewSession")
+	return b.newSession(conn, ListenerSMTP)
+}
+
+// newSession starts a session for conn accepted by the named listener.
+func (b *smtpBackend) newSession(conn *smtp.Conn, listener string) (smtp.Session, error) {
+	slog.Info("NewSession", "listener", listener)
 
-	_, tlsOK := conn.TLSConnectionState()
 	s := &smtpSession{
-		receivedTime: time.Now(),
-		clientAddr:   conn.Conn().RemoteAddr().String(),
-		clientHost:   conn.Hostname(),
-		tlsUsed:      tlsOK,
-		rcptTo:       make([]string, 0),
-		rcptOpts:     make([]*smtp.RcptOptions, 0),
+		backend: b,
+		conn: &smtpConnection{
+			id:            b.connSeq.Add(1),
+			connectedTime: time.Now(),
+			listener:      listener,
+			clientAddr:    conn.Conn().RemoteAddr().String(),
+			clientHost:    conn.Hostname(),
+		},
+	}
+	// After STARTTLS the client starts over with a new session on the encrypted connection.
+	if state, ok := conn.TLSConnectionState(); ok {
+		s.conn.setTLS(state)
+	}
+	s.Reset()
+
+	return s, nil
+}
+
+// newMessageID returns a unique, lexically sortable message ID.
+func newMessageID() string {
+	return ulid.Make().String()
+}
+
+// addMessage parses and stores a completed message transaction and wakes up waiters.
+// A message without an ID is assigned a new one.
+func (b *smtpBackend) addMessage(msg *smtpMessage) error {
+	if msg.id == "" {
+		msg.id = newMessageID()
 	}
 
+	// Parse outside the lock; the view is cached on the message from now on.
+	msg.parse()
+	summary := newSMTPSummary(*msg.view)
+
 	b.mux.Lock()
-	b.sessions = append(b.sessions, s)
+	if err := b.store.Append(msg); err != nil {
+		b.mux.Unlock()
+
+		return fmt.Errorf("store message: %w", err)
+	}
+	b.publishLocked(eventMessageReceived, summary)
+
+	// The message is stored at this point; a failed eviction must not reject it.
+	if err := b.enforceRetentionLocked(time.Now()); err != nil {
+		slog.Error("failed to enforce retention", "error", err)
+	}
 	b.mux.Unlock()
 
-	return s, nil
+	b.deliverToMaildir(msg)
+
+	return nil
 }
 
-func (b *smtpBackend) GetAllData() []smtpView {
-	b.mux.RLock()
-	sessions := make([]*smtpSession, len(b.sessions))
-	copy(sessions, b.sessions)
-	b.mux.RUnlock()
-
-	result := make([]smtpView, len(sessions))
-	for i, session := range sessions {
-		view := smtpView{
-			// SMTP transaction data
-			SMTPFrom:      session.mailFrom,
-			SMTPTo:        session.rcptTo,
-			ReceivedTime:  session.receivedTime,
-			ClientAddr:    session.clientAddr,
-			ClientHost:    session.clientHost,
-			TLSUsed:       session.tlsUsed,
-			Authenticated: session.authenticated,
-			AuthMechanism: session.authMechanism,
+// WaitForMessages blocks until at least count messages match filter or ctx is done.
+// On timeout it returns the messages matched so far together with the context error.
+func (b *smtpBackend) WaitForMessages(ctx context.Context, filter messageFilter, count int) ([]smtpView, error) {
+	for {
+		// Subscribe before searching so a message stored in between is not missed.
+		ch := b.store.Subscribe()
+
+		results, err := b.Search(filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) >= count {
+			return results, nil
 		}
 
-		// Parse email content if available
-		if session.data != "" {
-			e, err := enmime.ReadEnvelope(strings.NewReader(session.data))
-			if err != nil {
-				slog.Info("failed to read envelope", "error", err)
-				view.Text = "cannot parse this mail"
-			} else {
-				view.FromAddressList = getAddressList(e, "from")
-				view.ToAddressList = getAddressList(e, "to")
-				view.CcAddressList = getAddressList(e, "cc")
-				view.BccAddressList = getAddressList(e, "bcc")
-				view.Text = e.Text
-				view.HTML = e.HTML
-
-				// Parse headers (excluding address headers)
-				keys := e.GetHeaderKeys()
-				view.Headers = make([]*smtpViewHeader, 0, len(keys))
-				for _, h := range keys {
-					if !isAddressHeader(h) {
-						view.Headers = append(view.Headers, &smtpViewHeader{
-							Key:   h,
-							Value: e.GetHeader(h),
-						})
-					}
-				}
-			}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return results, fmt.Errorf("wait for messages: %w", ctx.Err())
 		}
+	}
+}
+
+func (b *smtpBackend) GetAllData() []smtpView {
+	return messageViews(b.store.List())
+}
+
+// GetByID returns the message with the given ID.
+func (b *smtpBackend) GetByID(id string) (smtpView, bool) {
+	msg, ok := b.store.Get(id)
+	if !ok {
+		return smtpView{}, false
+	}
+
+	return *msg.view, true
+}
 
-		result[i] = view
+// GetRawByID returns the original DATA payload of the message with the given ID.
+func (b *smtpBackend) GetRawByID(id string) (string, bool) {
+	msg, ok := b.store.Get(id)
+	if !ok {
+		return "", false
+	}
+
+	return msg.data, true
+}
+
+// messageViews returns the cached views of messages.
+func messageViews(messages []*smtpMessage) []smtpView {
+	result := make([]smtpView, len(messages))
+	for i, msg := range messages {
+		result[i] = *msg.view
 	}
 
 	return result
 }
 
+func newSMTPView(msg *smtpMessage) smtpView {
+	view := smtpView{
+		ID: msg.id,
+
+		// SMTP transaction data
+		SMTPFrom:     msg.mailFrom,
+		SMTPTo:       msg.rcptTo,
+		ReceivedTime: msg.receivedTime,
+
+		// Connection metadata
+		ConnectionID:     msg.conn.id,
+		Listener:         msg.conn.listener,
+		ClientAddr:       msg.conn.clientAddr,
+		ClientHost:       msg.conn.clientHost,
+		TLSUsed:          msg.conn.tlsUsed,
+		TLSVersion:       msg.conn.tlsVersion,
+		TLSCipherSuite:   msg.conn.tlsCipherSuite,
+		TLSServerName:    msg.conn.tlsServerName,
+		TLSClientSubject: msg.conn.tlsClientSubject,
+		Authenticated:    msg.conn.authenticated,
+		AuthMechanism:    msg.conn.authMechanism,
+		AuthUser:         msg.conn.authUser,
+		AuthPassword:     msg.conn.authPassword,
+	}
+
+	// Parse email content if available
+	if msg.data == "" {
+		return view
+	}
+
+	// The header block is kept even if the MIME structure cannot be parsed.
+	view.Headers = parseHeaderBlock(msg.data)
+
+	e, err := enmime.ReadEnvelope(strings.NewReader(msg.data))
+	if err != nil {
+		slog.Info("failed to read envelope", "error", err)
+		view.Text = "cannot parse this mail"
+		view.ParseError = err.Error()
+
+		return view
+	}
+
+	view.FromAddressList = getAddressList(e, "from")
+	view.ToAddressList = getAddressList(e, "to")
+	view.CcAddressList = getAddressList(e, "cc")
+	view.BccAddressList = getAddressList(e, "bcc")
+	view.Subject = e.GetHeader("Subject")
+	view.Text = e.Text
+	view.HTML = e.HTML
+	view.Attachments = newSMTPViewAttachments(e)
+
+	return view
+}
+
 func getAddressList(e *enmime.Envelope, key string) []*mail.Address {
 	addrList, err := e.AddressList(key)
 	if err != nil {
@@ -148,121 +318,245 @@ func getAddressList(e *enmime.Envelope, key string) []*mail.Address {
 	return addrList
 }
 
-func isAddressHeader(header string) bool {
-	switch strings.ToLower(header) {
-	case "to", "cc", "bcc", "from":
-		return true
-	default:
-		return false
-	}
-}
-
 // SearchByField searches for emails containing the specified email address in the given field.
 func (b *smtpBackend) SearchByField(field, email string) ([]smtpView, error) {
-	// Validate field parameter
-	if field == "" {
-		return nil, fmt.Errorf("%w: empty field", ErrInvalidSearchField)
+	if err := validateSearchField(field); err != nil {
+		return nil, err
 	}
 
-	// Validate field name before processing
-	switch field {
-	case FieldTo, FieldCC, FieldBCC, FieldFrom:
-		// Valid field, continue
-	default:
-		return nil, fmt.Errorf("%w: %s", ErrInvalidSearchField, field)
-	}
-
-	allData := b.GetAllData()
-	var results []smtpView
-
-	searchEmail := strings.ToLower(email)
-
-	for _, msg := range allData {
-		var found bool
-
-		switch field {
-		case FieldTo:
-			// Search both To header and SMTP RCPT TO
-			found = containsEmailInAddresses(msg.ToAddressList, searchEmail) ||
-				containsEmailInStrings(msg.SMTPTo, searchEmail)
-		case FieldCC:
-			found = containsEmailInAddresses(msg.CcAddressList, searchEmail)
-		case FieldBCC:
-			// BCC only visible in SMTP transaction, not in headers
-			found = containsEmailInAddresses(msg.BccAddressList, searchEmail)
-		case FieldFrom:
-			// Search both From header and SMTP MAIL FROM
-			found = containsEmailInAddresses(msg.FromAddressList, searchEmail) ||
-				strings.ToLower(msg.SMTPFrom) == searchEmail
+	messages := b.store.Search(field, email)
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	return messageViews(messages), nil
+}
+
+// messageFilter selects messages the same way the /search endpoints do.
+// The zero value matches every message.
+type messageFilter struct {
+	field    string
+	email    string
+	query    *searchQuery  // Additionally required to match when set
+	header   *headerFilter // Additionally required to match when set
+	text     string        // Full-text query every message must match when set, see textQueryTokens
+	authUser string        // Username the connection must have authenticated as when set
+	since    time.Time     // Inclusive lower bound of receivedTime, zero for none
+	until    time.Time     // Exclusive upper bound of receivedTime, zero for none
+}
+
+// matching returns the stored messages matching filter in arrival order.
+// With a full-text query it also returns the relevance of each message.
+func (b *smtpBackend) matching(filter messageFilter) ([]*smtpMessage, map[*smtpMessage]float64, error) {
+	if filter.field != "" {
+		if err := validateSearchField(filter.field); err != nil {
+			return nil, nil, err
 		}
+	}
 
-		if found {
-			results = append(results, msg)
+	var (
+		messages []*smtpMessage
+		scores   map[*smtpMessage]float64
+	)
+	switch {
+	case filter.text != "":
+		hits := b.store.SearchText(textQueryTokens(filter.text))
+		messages = make([]*smtpMessage, 0, len(hits))
+		scores = make(map[*smtpMessage]float64, len(hits))
+		for _, hit := range hits {
+			if filter.field != "" && !slices.Contains(addressKeys(hit.msg.view, filter.field), strings.ToLower(filter.email)) {
+				continue
+			}
+			messages = append(messages, hit.msg)
+			scores[hit.msg] = hit.score
 		}
+	case filter.field != "":
+		messages = b.store.Search(filter.field, filter.email)
+	default:
+		messages = b.store.List()
 	}
 
-	return results, nil
+	if filter.query != nil {
+		messages = slices.DeleteFunc(messages, func(msg *smtpMessage) bool { return !filter.query.matches(msg) })
+	}
+	if filter.header != nil {
+		messages = slices.DeleteFunc(messages, func(msg *smtpMessage) bool { return !filter.header.matches(msg) })
+	}
+	if filter.authUser != "" {
+		messages = slices.DeleteFunc(messages, func(msg *smtpMessage) bool { return msg.conn.authUser != filter.authUser })
+	}
+	if !filter.since.IsZero() || !filter.until.IsZero() {
+		messages = slices.DeleteFunc(messages, func(msg *smtpMessage) bool {
+			return (!filter.since.IsZero() && msg.receivedTime.Before(filter.since)) ||
+				(!filter.until.IsZero() && !msg.receivedTime.Before(filter.until))
+		})
+	}
+
+	return messages, scores, nil
 }
 
-// containsEmailInAddresses checks if an email address is present in a slice of mail.Address.
-func containsEmailInAddresses(addresses []*mail.Address, searchEmail string) bool {
-	for _, addr := range addresses {
-		if addr != nil && strings.ToLower(addr.Address) == searchEmail {
-			return true
-		}
+// Search returns the messages matching filter.
+func (b *smtpBackend) Search(filter messageFilter) ([]smtpView, error) {
+	messages, _, err := b.matching(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return messageViews(messages), nil
+}
+
+// DeleteAll removes every stored message and returns how many were removed.
+func (b *smtpBackend) DeleteAll() (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	n, err := b.store.DeleteAll()
+	if err != nil {
+		return 0, fmt.Errorf("delete all messages: %w", err)
 	}
+	b.publishLocked(eventMailboxCleared, clearedEventData{Deleted: n})
 
-	return false
+	return n, nil
 }
 
-// containsEmailInStrings checks if an email address is present in a slice of strings.
-func containsEmailInStrings(emails []string, searchEmail string) bool {
-	for _, email := range emails {
-		if strings.ToLower(email) == searchEmail {
-			return true
+// DeleteByID removes the message with the given ID and reports whether it existed.
+func (b *smtpBackend) DeleteByID(id string) (bool, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	ok, err := b.store.Delete(id)
+	if err != nil {
+		return false, fmt.Errorf("delete message: %w", err)
+	}
+	if ok {
+		b.publishLocked(eventMessageDeleted, deletedEventData{ID: id})
+	}
+
+	return ok, nil
+}
+
+// DeleteMatching removes every message matching filter and returns how many were removed.
+func (b *smtpBackend) DeleteMatching(filter messageFilter) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	messages, _, err := b.matching(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, msg := range messages {
+		ok, err := b.store.Delete(msg.id)
+		if err != nil {
+			return n, fmt.Errorf("delete message: %w", err)
+		}
+		if ok {
+			n++
+			b.publishLocked(eventMessageDeleted, deletedEventData{ID: msg.id})
 		}
 	}
 
-	return false
+	return n, nil
 }
 
-type smtpSession struct {
-	// Existing fields
-	data         string
-	receivedTime time.Time
+// validateSearchField checks that field is one of the supported search fields.
+func validateSearchField(field string) error {
+	// Validate field parameter
+	if field == "" {
+		return fmt.Errorf("%w: empty field", ErrInvalidSearchField)
+	}
 
-	// SMTP Transaction Data
-	mailFrom string              // MAIL FROM address
-	mailOpts *smtp.MailOptions   // MAIL FROM options (SIZE, BODY, etc.)
-	rcptTo   []string            // All RCPT TO addresses
-	rcptOpts []*smtp.RcptOptions // RCPT TO options (DSN, etc.)
+	// Validate field name before processing
+	switch field {
+	case FieldTo, FieldCC, FieldBCC, FieldFrom:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidSearchField, field)
+	}
+}
+
+// smtpConnection holds the metadata of a single SMTP connection.
+// It is shared by every message received over that connection.
+type smtpConnection struct {
+	id            uint64
+	connectedTime time.Time
+	listener      string // Name of the listener that accepted the connection
 
 	// Connection Info
 	clientAddr string // Client IP address
 	clientHost string // HELO/EHLO hostname
 	tlsUsed    bool   // Whether TLS was used
 
-	// Authentication (for future enhancement)
+	// TLS parameters, set when tlsUsed
+	tlsVersion       string // Protocol version name
+	tlsCipherSuite   string // Cipher suite name
+	tlsServerName    string // SNI requested by the client
+	tlsClientSubject string // Subject of the client certificate, if one was sent
+
+	// tlsState is the handshake of the connection, checked against the TLS policy. It is not stored.
+	tlsState *tls.ConnectionState
+
+	// Authentication, see auth.go
 	authenticated bool   // Whether auth succeeded
 	authMechanism string // PLAIN, LOGIN, etc.
+	authUser      string // Username that authenticated
+	authPassword  string // Password as captured by smtpAuth.capturedPassword
 }
 
-var _ smtp.Session = (*smtpSession)(nil)
+// smtpMessage is a single MAIL/RCPT/DATA transaction.
+type smtpMessage struct {
+	id   string
+	conn *smtpConnection
 
-func (s *smtpSession) AuthPlain(_, _ string) error {
-	return nil
+	// view is parsed once when the message is stored, see parse.
+	view *smtpView
+
+	data         string
+	receivedTime time.Time
+
+	// SMTP Transaction Data
+	mailFrom string              // MAIL FROM address
+	mailOpts *smtp.MailOptions   // MAIL FROM options (SIZE, BODY, etc.)
+	rcptTo   []string            // All RCPT TO addresses
+	rcptOpts []*smtp.RcptOptions // RCPT TO options (DSN, etc.)
+}
+
+// parse builds and caches the view of m unless that already happened.
+func (m *smtpMessage) parse() {
+	if m.view == nil {
+		view := newSMTPView(m)
+		m.view = &view
+	}
+}
+
+type smtpSession struct {
+	backend *smtpBackend
+	conn    *smtpConnection
+
+	// msg is the envelope of the transaction in progress.
+	msg *smtpMessage
 }
 
+var _ smtp.Session = (*smtpSession)(nil)
+
 func (s *smtpSession) Mail(from string, opts *smtp.MailOptions) error {
-	s.mailFrom = from
-	s.mailOpts = opts
+	if err := s.backend.tlsPolicy.check(s.conn); err != nil {
+		return err
+	}
+	if err := s.authRequired(); err != nil {
+		return err
+	}
+
+	s.msg.mailFrom = from
+	s.msg.mailOpts = opts
 
 	return nil
 }
 
 func (s *smtpSession) Rcpt(to string, opts *smtp.RcptOptions) error {
-	s.rcptTo = append(s.rcptTo, to)
-	s.rcptOpts = append(s.rcptOpts, opts)
+	s.msg.rcptTo = append(s.msg.rcptTo, to)
+	s.msg.rcptOpts = append(s.msg.rcptOpts, opts)
 
 	return nil
 }
@@ -273,22 +567,99 @@ func (s *smtpSession) Data(r io.Reader) error {
 		return fmt.Errorf("read data error: %w", err)
 	}
 
-	s.data = string(b)
-	// slog.Info("Received data", "data", s.data)
+	s.msg.data = string(b)
+	s.msg.receivedTime = time.Now()
+	// slog.Info("Received data", "data", s.msg.data)
+
+	if err := s.backend.addMessage(s.msg); err != nil {
+		slog.Error("failed to store message", "error", err)
+
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Failed to store message",
+		}
+	}
 
 	return nil
 }
 
-func (s *smtpSession) Reset() {}
+// Reset discards the current envelope and starts a fresh one on the same connection.
+func (s *smtpSession) Reset() {
+	s.msg = &smtpMessage{
+		conn:     s.conn,
+		rcptTo:   make([]string, 0),
+		rcptOpts: make([]*smtp.RcptOptions, 0),
+	}
+}
 
 func (s *smtpSession) Logout() error {
 	return nil
 }
 
 func StartSMTPServer(cfg *config.Config) error {
-	s := smtp.NewServer(sharedBackend)
+	s := newSMTPServer(cfg, sharedBackend)
+
+	slog.Info("Starting SMTP fake server", "addr", s.Addr, "starttls", s.TLSConfig != nil)
+	if err := s.ListenAndServe(); err != nil {
+		slog.Info("err", "error", err)
+
+		return fmt.Errorf("smtp server error: %w", err)
+	}
+
+	return nil
+}
+
+// StartSMTPSServer starts the implicit TLS listener at cfg.SMTPSAddr, which stores into the same
+// backend as the plaintext listener. ConfigureTLS must have been called.
+func StartSMTPSServer(cfg *config.Config) error {
+	s := newSMTPSServer(cfg, sharedBackend)
+
+	slog.Info("Starting SMTPS fake server", "addr", s.Addr)
+	if err := s.ListenAndServeTLS(); err != nil {
+		slog.Info("err", "error", err)
 
+		return fmt.Errorf("smtps server error: %w", err)
+	}
+
+	return nil
+}
+
+// smtpListener is the smtp.Backend of one listener, it names the listener on every connection.
+type smtpListener struct {
+	backend *smtpBackend
+	name    string
+}
+
+func (l *smtpListener) NewSession(conn *smtp.Conn) (smtp.Session, error) {
+	return l.backend.newSession(conn, l.name)
+}
+
+// newSMTPServer returns the plaintext SMTP server for backend configured by cfg.
+func newSMTPServer(cfg *config.Config, backend *smtpBackend) *smtp.Server {
+	s := newServer(cfg, backend, ListenerSMTP)
 	s.Addr = cfg.SMTPAddr
+	if cfg.SMTPStartTLS && backend.tls != nil {
+		s.TLSConfig = backend.tls.config
+	}
+
+	return s
+}
+
+// newSMTPSServer returns the implicit TLS SMTP server for backend configured by cfg.
+func newSMTPSServer(cfg *config.Config, backend *smtpBackend) *smtp.Server {
+	s := newServer(cfg, backend, ListenerSMTPS)
+	s.Addr = cfg.SMTPSAddr
+	if backend.tls != nil {
+		s.TLSConfig = backend.tls.config
+	}
+
+	return s
+}
+
+func newServer(cfg *config.Config, backend *smtpBackend, listener string) *smtp.Server {
+	s := smtp.NewServer(&smtpListener{backend: backend, name: listener})
+
 	s.Domain = cfg.SMTPHostname
 	s.ReadTimeout = cfg.SMTPReadTimeout
 	s.WriteTimeout = cfg.SMTPWriteTimeout
@@ -297,12 +668,5 @@ func StartSMTPServer(cfg *config.Config) error {
 	s.AllowInsecureAuth = cfg.SMTPAllowInsecureAuth
 	// s.Debug = os.Stdout
 
-	slog.Info("Starting SMTP fake server", "addr", s.Addr)
-	if err := s.ListenAndServe(); err != nil {
-		slog.Info("err", "error", err)
-
-		return fmt.Errorf("smtp server error: %w", err)
-	}
-
-	return nil
+	return s
 }
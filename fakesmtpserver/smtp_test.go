@@ -1,7 +1,7 @@
 package fakesmtpserver
 
 import (
-	"net/mail"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -11,41 +11,49 @@ import (
 
 func TestSearchByField(t *testing.T) {
 	// Create a test backend with sample data
-	backend := &smtpBackend{}
+	backend := newSMTPBackend()
 
 	// Create test sessions with different email scenarios
-	session1 := &smtpSession{
+	msg1 := &smtpMessage{
 		data:         createTestEmailData("sender1@example.com", "recipient1@example.com", "Test Subject 1"),
 		receivedTime: time.Now(),
 		mailFrom:     "sender1@example.com",
 		rcptTo:       []string{"recipient1@example.com", "recipient2@example.com"},
-		clientAddr:   "192.168.1.100:12345",
-		clientHost:   "client1.example.com",
-		tlsUsed:      true,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.100:12345",
+			clientHost: "client1.example.com",
+			tlsUsed:    true,
+		},
 	}
 
-	session2 := &smtpSession{
+	msg2 := &smtpMessage{
 		data:         createTestEmailData("sender2@example.com", "recipient3@example.com", "Test Subject 2"),
 		receivedTime: time.Now(),
 		mailFrom:     "sender2@example.com",
 		rcptTo:       []string{"recipient3@example.com"},
-		clientAddr:   "192.168.1.101:12346",
-		clientHost:   "client2.example.com",
-		tlsUsed:      false,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.101:12346",
+			clientHost: "client2.example.com",
+			tlsUsed:    false,
+		},
 	}
 
 	// Test session with CC and BCC
-	session3 := &smtpSession{
+	msg3 := &smtpMessage{
 		data:         createTestEmailWithCC("sender3@example.com", "recipient4@example.com", "cc@example.com", "Test Subject 3"),
 		receivedTime: time.Now(),
 		mailFrom:     "sender3@example.com",
 		rcptTo:       []string{"recipient4@example.com", "cc@example.com", "bcc@example.com"},
-		clientAddr:   "192.168.1.102:12347",
-		clientHost:   "client3.example.com",
-		tlsUsed:      true,
+		conn: &smtpConnection{
+			clientAddr: "192.168.1.102:12347",
+			clientHost: "client3.example.com",
+			tlsUsed:    true,
+		},
 	}
 
-	backend.sessions = []*smtpSession{session1, session2, session3}
+	addTestMessage(t, backend, msg1)
+	addTestMessage(t, backend, msg2)
+	addTestMessage(t, backend, msg3)
 
 	tests := []struct {
 		name          string
@@ -113,19 +121,7 @@ func TestSearchByField(t *testing.T) {
 			if tt.expectedCount > 0 && len(results) > 0 {
 				found := false
 				for _, result := range results {
-					if tt.field == "to" && (containsEmailInAddresses(result.ToAddressList, strings.ToLower(tt.email)) ||
-						containsEmailInStrings(result.SMTPTo, strings.ToLower(tt.email))) {
-						found = true
-
-						break
-					}
-					if tt.field == "from" && (containsEmailInAddresses(result.FromAddressList, strings.ToLower(tt.email)) ||
-						strings.EqualFold(result.SMTPFrom, tt.email)) {
-						found = true
-
-						break
-					}
-					if tt.field == "cc" && containsEmailInAddresses(result.CcAddressList, strings.ToLower(tt.email)) {
+					if slices.Contains(addressKeys(&result, tt.field), strings.ToLower(tt.email)) {
 						found = true
 
 						break
@@ -140,7 +136,7 @@ func TestSearchByField(t *testing.T) {
 }
 
 func TestSearchByFieldErrors(t *testing.T) {
-	backend := &smtpBackend{}
+	backend := newSMTPBackend()
 
 	tests := []struct {
 		name    string
@@ -190,147 +186,41 @@ func TestSearchByFieldErrors(t *testing.T) {
 	}
 }
 
-func TestContainsEmailInAddresses(t *testing.T) {
-	addresses := []*mail.Address{
-		{Name: "John Doe", Address: "john@example.com"},
-		{Name: "Jane Smith", Address: "jane@example.com"},
-		{Name: "", Address: "no-name@example.com"},
-	}
+func TestDeleteMatching(t *testing.T) {
+	backend := newSMTPBackend()
+	setupTestData(t, backend)
 
-	tests := []struct {
-		name        string
-		addresses   []*mail.Address
-		searchEmail string
-		want        bool
-	}{
-		{
-			name:        "email_found",
-			addresses:   addresses,
-			searchEmail: "john@example.com",
-			want:        true,
-		},
-		{
-			name:        "email_not_found",
-			addresses:   addresses,
-			searchEmail: "notfound@example.com",
-			want:        false,
-		},
-		{
-			name:        "case_insensitive_found",
-			addresses:   addresses,
-			searchEmail: "JANE@EXAMPLE.COM",
-			want:        true,
-		},
-		{
-			name:        "empty_addresses",
-			addresses:   []*mail.Address{},
-			searchEmail: "test@example.com",
-			want:        false,
-		},
-		{
-			name:        "nil_addresses",
-			addresses:   nil,
-			searchEmail: "test@example.com",
-			want:        false,
-		},
+	if _, err := backend.DeleteMatching(messageFilter{field: "invalid", email: "test@example.com"}); err == nil {
+		t.Error("DeleteMatching() with invalid field should error")
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := containsEmailInAddresses(tt.addresses, strings.ToLower(tt.searchEmail)); got != tt.want {
-				t.Errorf("containsEmailInAddresses() = %v, want %v", got, tt.want)
-			}
-		})
+	deleted, err := backend.DeleteMatching(messageFilter{field: FieldCC, email: "CC@example.com"})
+	if err != nil {
+		t.Fatalf("DeleteMatching() error = %v", err)
 	}
-}
-
-func TestContainsEmailInStrings(t *testing.T) {
-	emails := []string{
-		"test1@example.com",
-		"test2@example.com",
-		"test3@example.com",
+	if deleted != 1 {
+		t.Errorf("DeleteMatching() deleted = %d, want 1", deleted)
 	}
 
-	tests := []struct {
-		name        string
-		emails      []string
-		searchEmail string
-		want        bool
-	}{
-		{
-			name:        "email_found",
-			emails:      emails,
-			searchEmail: "test2@example.com",
-			want:        true,
-		},
-		{
-			name:        "email_not_found",
-			emails:      emails,
-			searchEmail: "notfound@example.com",
-			want:        false,
-		},
-		{
-			name:        "case_insensitive_found",
-			emails:      emails,
-			searchEmail: "TEST1@EXAMPLE.COM",
-			want:        true,
-		},
-		{
-			name:        "empty_emails",
-			emails:      []string{},
-			searchEmail: "test@example.com",
-			want:        false,
-		},
-		{
-			name:        "nil_emails",
-			emails:      nil,
-			searchEmail: "test@example.com",
-			want:        false,
-		},
+	results, err := backend.SearchByField("cc", "cc@example.com")
+	if err != nil {
+		t.Fatalf("SearchByField() error = %v", err)
 	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := containsEmailInStrings(tt.emails, strings.ToLower(tt.searchEmail)); got != tt.want {
-				t.Errorf("containsEmailInStrings() = %v, want %v", got, tt.want)
-			}
-		})
+	if len(results) != 0 {
+		t.Errorf("SearchByField() after delete = %d results, want 0", len(results))
 	}
-}
-
-func TestIsAddressHeader(t *testing.T) {
-	tests := []struct {
-		name   string
-		header string
-		want   bool
-	}{
-		{"to_header", "to", true},
-		{"cc_header", "cc", true},
-		{"bcc_header", "bcc", true},
-		{"from_header", "from", true},
-		{"case_insensitive_to", "TO", true},
-		{"case_insensitive_from", "FROM", true},
-		{"subject_header", "subject", false},
-		{"date_header", "date", false},
-		{"empty_header", "", false},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := isAddressHeader(tt.header); got != tt.want {
-				t.Errorf("isAddressHeader() = %v, want %v", got, tt.want)
-			}
-		})
+	if got := len(backend.GetAllData()); got != 2 {
+		t.Errorf("GetAllData() after delete = %d messages, want 2", got)
 	}
 }
 
 func TestSMTPSessionDataCapture(t *testing.T) {
 	// Test that SMTP session methods properly capture data
 	session := &smtpSession{
-		receivedTime: time.Now(),
-		rcptTo:       make([]string, 0),
-		rcptOpts:     make([]*smtp.RcptOptions, 0),
+		backend: newSMTPBackend(),
+		conn:    &smtpConnection{id: 1},
 	}
+	session.Reset()
 
 	// Test Mail method
 	testFrom := "sender@example.com"
@@ -339,10 +229,10 @@ func TestSMTPSessionDataCapture(t *testing.T) {
 	if err != nil {
 		t.Errorf("Mail() error = %v", err)
 	}
-	if session.mailFrom != testFrom {
-		t.Errorf("Mail() mailFrom = %v, want %v", session.mailFrom, testFrom)
+	if session.msg.mailFrom != testFrom {
+		t.Errorf("Mail() mailFrom = %v, want %v", session.msg.mailFrom, testFrom)
 	}
-	if session.mailOpts != mailOpts {
+	if session.msg.mailOpts != mailOpts {
 		t.Errorf("Mail() mailOpts not captured correctly")
 	}
 
@@ -360,11 +250,82 @@ func TestSMTPSessionDataCapture(t *testing.T) {
 		t.Errorf("Rcpt() error = %v", err)
 	}
 
-	if len(session.rcptTo) != 2 {
-		t.Errorf("Rcpt() rcptTo length = %d, want 2", len(session.rcptTo))
+	if len(session.msg.rcptTo) != 2 {
+		t.Errorf("Rcpt() rcptTo length = %d, want 2", len(session.msg.rcptTo))
 	}
-	if session.rcptTo[0] != testTo1 || session.rcptTo[1] != testTo2 {
-		t.Errorf("Rcpt() rcptTo = %v, want [%s, %s]", session.rcptTo, testTo1, testTo2)
+	if session.msg.rcptTo[0] != testTo1 || session.msg.rcptTo[1] != testTo2 {
+		t.Errorf("Rcpt() rcptTo = %v, want [%s, %s]", session.msg.rcptTo, testTo1, testTo2)
+	}
+}
+
+func TestSMTPSessionMultipleMessages(t *testing.T) {
+	// Test that every transaction on a single connection is captured separately
+	backend := newSMTPBackend()
+	session := &smtpSession{
+		backend: backend,
+		conn: &smtpConnection{
+			id:         7,
+			clientAddr: "192.168.1.100:12345",
+			clientHost: "client.example.com",
+		},
+	}
+	session.Reset()
+
+	transactions := []struct {
+		from string
+		to   []string
+	}{
+		{from: "sender1@example.com", to: []string{"recipient1@example.com"}},
+		{from: "sender2@example.com", to: []string{"recipient2@example.com", "recipient3@example.com"}},
+	}
+
+	for _, tx := range transactions {
+		if err := session.Mail(tx.from, &smtp.MailOptions{}); err != nil {
+			t.Fatalf("Mail() error = %v", err)
+		}
+		for _, to := range tx.to {
+			if err := session.Rcpt(to, &smtp.RcptOptions{}); err != nil {
+				t.Fatalf("Rcpt() error = %v", err)
+			}
+		}
+		if err := session.Data(strings.NewReader(createTestEmailData(tx.from, tx.to[0], "Test Subject"))); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		session.Reset()
+	}
+
+	// A RSET without DATA must not produce a message
+	if err := session.Mail("aborted@example.com", &smtp.MailOptions{}); err != nil {
+		t.Fatalf("Mail() error = %v", err)
+	}
+	session.Reset()
+
+	views := backend.GetAllData()
+	if len(views) != len(transactions) {
+		t.Fatalf("GetAllData() = %d messages, want %d", len(views), len(transactions))
+	}
+
+	if views[0].ID == "" || views[0].ID == views[1].ID {
+		t.Errorf("messages should have unique IDs, got %q and %q", views[0].ID, views[1].ID)
+	}
+
+	for i, tx := range transactions {
+		view := views[i]
+		if view.SMTPFrom != tx.from {
+			t.Errorf("message %d SMTPFrom = %s, want %s", i, view.SMTPFrom, tx.from)
+		}
+		if len(view.SMTPTo) != len(tx.to) {
+			t.Errorf("message %d SMTPTo = %v, want %v", i, view.SMTPTo, tx.to)
+		}
+		if view.ConnectionID != 7 {
+			t.Errorf("message %d ConnectionID = %d, want 7", i, view.ConnectionID)
+		}
+		if view.ClientHost != "client.example.com" {
+			t.Errorf("message %d ClientHost = %s, want client.example.com", i, view.ClientHost)
+		}
+		if view.ReceivedTime.IsZero() {
+			t.Errorf("message %d ReceivedTime should be set", i)
+		}
 	}
 }
 
@@ -385,3 +346,12 @@ func createTestEmailWithCC(from, to, cc, subject string) string {
 		"\r\n" +
 		"This is a test email body with CC.\r\n"
 }
+
+// addTestMessage stores msg in backend and fails the test if that does not work.
+func addTestMessage(tb testing.TB, backend *smtpBackend, msg *smtpMessage) {
+	tb.Helper()
+
+	if err := backend.addMessage(msg); err != nil {
+		tb.Fatalf("addMessage() error = %v", err)
+	}
+}
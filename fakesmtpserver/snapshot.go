@@ -0,0 +1,191 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"time"
+)
+
+var (
+	// ErrInvalidSnapshotName is returned when a snapshot name is not 1-64 letters, digits, ".", "_" or "-".
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+	// ErrSnapshotNotFound is returned when no snapshot has the requested name.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type (
+	// mailboxSnapshot is a named copy of the stored messages.
+	// Stored messages are never modified, so a snapshot shares them with the store.
+	mailboxSnapshot struct {
+		name        string
+		createdTime time.Time
+		messages    []*smtpMessage
+	}
+
+	// snapshotInfo describes a snapshot in list responses.
+	snapshotInfo struct {
+		Name        string    `json:"name"`
+		CreatedTime time.Time `json:"createdTime"`
+		Messages    int       `json:"messages"`
+	}
+
+	// snapshotDump is the file format of a dumped snapshot. It can be loaded back as a snapshot.
+	snapshotDump struct {
+		Name        string          `json:"name"`
+		CreatedTime time.Time       `json:"createdTime"`
+		Messages    []storedMessage `json:"messages"`
+	}
+
+	// restoredEventData is sent with mailbox.restored events.
+	restoredEventData struct {
+		Snapshot string `json:"snapshot"`
+		Messages int    `json:"messages"`
+	}
+)
+
+func validateSnapshotName(name string) error {
+	if !snapshotNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidSnapshotName, name)
+	}
+
+	return nil
+}
+
+func (s *mailboxSnapshot) info() snapshotInfo {
+	return snapshotInfo{Name: s.name, CreatedTime: s.createdTime, Messages: len(s.messages)}
+}
+
+// CreateSnapshot saves the stored messages under name, replacing an existing snapshot of that name.
+func (b *smtpBackend) CreateSnapshot(name string) (snapshotInfo, error) {
+	if err := validateSnapshotName(name); err != nil {
+		return snapshotInfo{}, err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	snapshot := &mailboxSnapshot{name: name, createdTime: time.Now(), messages: b.store.List()}
+	b.saveSnapshotLocked(snapshot)
+
+	return snapshot.info(), nil
+}
+
+func (b *smtpBackend) saveSnapshotLocked(snapshot *mailboxSnapshot) {
+	if b.snapshots == nil {
+		b.snapshots = make(map[string]*mailboxSnapshot)
+	}
+	b.snapshots[snapshot.name] = snapshot
+}
+
+// Snapshots returns every snapshot ordered by name.
+func (b *smtpBackend) Snapshots() []snapshotInfo {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	names := slices.Sorted(maps.Keys(b.snapshots))
+	infos := make([]snapshotInfo, len(names))
+	for i, name := range names {
+		infos[i] = b.snapshots[name].info()
+	}
+
+	return infos
+}
+
+// RestoreSnapshot atomically replaces the stored messages with the snapshot called name
+// and evicts what the retention limits no longer allow, like a received message does.
+// The snapshot itself is kept, so it can be restored again.
+func (b *smtpBackend) RestoreSnapshot(name string) (snapshotInfo, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	snapshot, ok := b.snapshots[name]
+	if !ok {
+		return snapshotInfo{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+
+	if err := b.store.Replace(snapshot.messages); err != nil {
+		return snapshotInfo{}, fmt.Errorf("restore snapshot: %w", err)
+	}
+	b.publishLocked(eventMailboxRestored, restoredEventData{Snapshot: name, Messages: len(snapshot.messages)})
+
+	// The snapshot is restored at this point; a failed eviction must not report it as failed.
+	if err := b.enforceRetentionLocked(time.Now()); err != nil {
+		slog.Error("failed to enforce retention", "error", err)
+	}
+
+	return snapshot.info(), nil
+}
+
+// DeleteSnapshot removes the snapshot called name and reports whether it existed.
+func (b *smtpBackend) DeleteSnapshot(name string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	_, ok := b.snapshots[name]
+	delete(b.snapshots, name)
+
+	return ok
+}
+
+// DumpSnapshot returns the snapshot called name in its file format.
+func (b *smtpBackend) DumpSnapshot(name string) (snapshotDump, error) {
+	b.mux.RLock()
+	snapshot, ok := b.snapshots[name]
+	b.mux.RUnlock()
+
+	if !ok {
+		return snapshotDump{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+
+	dump := snapshotDump{
+		Name:        snapshot.name,
+		CreatedTime: snapshot.createdTime,
+		Messages:    make([]storedMessage, len(snapshot.messages)),
+	}
+	for i, msg := range snapshot.messages {
+		dump.Messages[i] = newStoredMessage(msg)
+	}
+
+	return dump, nil
+}
+
+// LoadSnapshot saves the messages of dump as the snapshot called name, replacing an existing one.
+// The stored messages are not touched until the snapshot is restored.
+func (b *smtpBackend) LoadSnapshot(name string, dump snapshotDump) (snapshotInfo, error) {
+	if err := validateSnapshotName(name); err != nil {
+		return snapshotInfo{}, err
+	}
+
+	snapshot := &mailboxSnapshot{
+		name:        name,
+		createdTime: dump.CreatedTime,
+		messages:    make([]*smtpMessage, len(dump.Messages)),
+	}
+	if snapshot.createdTime.IsZero() {
+		snapshot.createdTime = time.Now()
+	}
+	for i, record := range dump.Messages {
+		msg := record.message()
+		if msg.id == "" {
+			msg.id = newMessageID()
+		}
+		msg.parse()
+		snapshot.messages[i] = msg
+	}
+	if err := checkUniqueIDs(snapshot.messages); err != nil {
+		return snapshotInfo{}, err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.saveSnapshotLocked(snapshot)
+
+	return snapshot.info(), nil
+}
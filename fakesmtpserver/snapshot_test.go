@@ -0,0 +1,145 @@
+package fakesmtpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	backend := newSMTPBackend()
+	msgs := setupTestData(t, backend)
+
+	info, err := backend.CreateSnapshot("setup")
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if info.Name != "setup" || info.Messages != 3 {
+		t.Errorf("CreateSnapshot() = %+v", info)
+	}
+
+	// Diverge from the snapshot
+	if _, err := backend.DeleteByID(msgs[0].id); err != nil {
+		t.Fatalf("DeleteByID() error = %v", err)
+	}
+	addTestMessage(t, backend, newStoreTestMessage("later", "later@example.com", "Later"))
+
+	ch := backend.store.Subscribe()
+	if _, err := backend.RestoreSnapshot("setup"); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	assertStoreNotified(t, ch, true)
+
+	want := []string{msgs[0].id, msgs[1].id, msgs[2].id}
+	if got := storeMessageIDs(backend.store.List()); !slices.Equal(got, want) {
+		t.Errorf("messages after restore = %v, want %v", got, want)
+	}
+	if got := backend.store.Search(FieldTo, "later@example.com"); len(got) != 0 {
+		t.Errorf("search after restore found %d messages received after the snapshot", len(got))
+	}
+
+	events, _ := backend.eventsSince(backend.lastEventID() - 1)
+	if len(events) != 1 || events[0].Name != eventMailboxRestored {
+		t.Errorf("last event = %+v, want %s", events, eventMailboxRestored)
+	}
+
+	// Restoring twice gives the same state
+	if _, err := backend.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll() error = %v", err)
+	}
+	if _, err := backend.RestoreSnapshot("setup"); err != nil || backend.store.Len() != 3 {
+		t.Errorf("second RestoreSnapshot() = %v with %d messages, want 3", err, backend.store.Len())
+	}
+
+	if _, err := backend.RestoreSnapshot("missing"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("RestoreSnapshot(missing) error = %v, want %v", err, ErrSnapshotNotFound)
+	}
+	if _, err := backend.CreateSnapshot("../etc"); !errors.Is(err, ErrInvalidSnapshotName) {
+		t.Errorf("CreateSnapshot(../etc) error = %v, want %v", err, ErrInvalidSnapshotName)
+	}
+}
+
+func TestSnapshotRestoreAppliesRetention(t *testing.T) {
+	backend := newSMTPBackend()
+	msgs := setupTestData(t, backend)
+	if _, err := backend.CreateSnapshot("setup"); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+
+	// Limits tightened after the snapshot was taken also bound the restored messages
+	backend.retention = retentionPolicy{maxMessages: 2}
+	if _, err := backend.RestoreSnapshot("setup"); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+
+	want := []string{msgs[1].id, msgs[2].id}
+	if got := storeMessageIDs(backend.store.List()); !slices.Equal(got, want) {
+		t.Errorf("messages after restore = %v, want %v", got, want)
+	}
+	if got := backend.Stats().EvictedBy[evictReasonMaxMessages]; got != 1 {
+		t.Errorf("evicted for %s = %d, want 1", evictReasonMaxMessages, got)
+	}
+
+	events, _ := backend.eventsSince(backend.lastEventID() - 2)
+	if len(events) != 2 || events[0].Name != eventMailboxRestored || events[1].Name != eventMessageDeleted {
+		t.Errorf("last events = %+v, want %s then %s", events, eventMailboxRestored, eventMessageDeleted)
+	}
+}
+
+func TestSnapshotDumpLoad(t *testing.T) {
+	source := newSMTPBackend()
+	msgs := setupTestData(t, source)
+	if _, err := source.CreateSnapshot("fixture"); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+
+	dump, err := source.DumpSnapshot("fixture")
+	if err != nil {
+		t.Fatalf("DumpSnapshot() error = %v", err)
+	}
+
+	// Round trip through the file format
+	b, err := json.Marshal(dump)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var loaded snapshotDump
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	target := newSMTPBackend()
+	info, err := target.LoadSnapshot("imported", loaded)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if info.Name != "imported" || info.Messages != 3 || !info.CreatedTime.Equal(dump.CreatedTime) {
+		t.Errorf("LoadSnapshot() = %+v", info)
+	}
+	if target.store.Len() != 0 {
+		t.Error("LoadSnapshot() must not touch the stored messages")
+	}
+
+	if _, err := target.RestoreSnapshot("imported"); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	for _, msg := range msgs {
+		got, ok := target.GetByID(msg.id)
+		if !ok || got.Subject != msg.view.Subject || got.SMTPFrom != msg.mailFrom || got.ClientAddr != msg.conn.clientAddr {
+			t.Errorf("restored message %s = %+v", msg.id, got)
+		}
+	}
+
+	if got := target.Snapshots(); len(got) != 1 || got[0].Name != "imported" {
+		t.Errorf("Snapshots() = %+v", got)
+	}
+	if !target.DeleteSnapshot("imported") || target.DeleteSnapshot("imported") {
+		t.Error("DeleteSnapshot() should report true once")
+	}
+
+	duplicate := snapshotDump{Messages: []storedMessage{loaded.Messages[0], loaded.Messages[0]}}
+	if _, err := target.LoadSnapshot("dup", duplicate); !errors.Is(err, ErrDuplicateMessageID) {
+		t.Errorf("LoadSnapshot() with duplicate IDs error = %v, want %v", err, ErrDuplicateMessageID)
+	}
+}
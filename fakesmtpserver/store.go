@@ -0,0 +1,346 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+var (
+	// ErrUnknownStoreType is returned when config.Config.StoreType names no known store.
+	ErrUnknownStoreType = errors.New("unknown store type")
+	// ErrDuplicateMessageID is returned when a message is appended with an ID that is already stored.
+	ErrDuplicateMessageID = errors.New("duplicate message id")
+)
+
+const (
+	// Store types selectable through config.Config.StoreType.
+	StoreTypeMemory = "memory"
+	StoreTypeBolt   = "bolt"
+)
+
+// Store keeps captured messages for smtpBackend.
+// Messages are returned in arrival order. Implementations must be safe for concurrent use.
+type Store interface { //nolint:interfacebloat // backends must stay interchangeable
+	// Append stores msg, which must already have an ID.
+	Append(msg *smtpMessage) error
+	// Get returns the message with the given ID.
+	Get(id string) (*smtpMessage, bool)
+	// List returns every stored message.
+	List() []*smtpMessage
+	// Oldest returns the message that arrived first.
+	Oldest() (*smtpMessage, bool)
+	// ReceivedBefore returns the messages received before t, whatever their position in arrival order.
+	ReceivedBefore(t time.Time) []*smtpMessage
+	// Search returns the messages containing email in the given field.
+	Search(field, email string) []*smtpMessage
+	// SearchText returns the messages containing every full-text term with their relevance, see textTokens.
+	SearchText(terms []string) []textHit
+	// Delete removes the message with the given ID and reports whether it existed.
+	Delete(id string) (bool, error)
+	// DeleteAll removes every stored message and returns how many were removed.
+	DeleteAll() (int, error)
+	// Replace atomically replaces every stored message with messages, which must have unique IDs.
+	Replace(messages []*smtpMessage) error
+	// Len returns the number of stored messages.
+	Len() int
+	// Size returns the total size in bytes of the stored message data.
+	Size() int64
+	// Subscribe returns a channel that is closed the next time the stored messages change.
+	Subscribe() <-chan struct{}
+
+	io.Closer
+}
+
+// OpenStore replaces the message store of the shared backend with the one selected by cfg
+// and applies the retention and message size limits of cfg to it.
+// It must be called before the servers are started. The returned store has to be closed on shutdown.
+func OpenStore(cfg *config.Config) (io.Closer, error) {
+	var (
+		store Store
+		err   error
+	)
+
+	switch cfg.StoreType {
+	case StoreTypeMemory:
+		store = newMemoryStore()
+	case StoreTypeBolt:
+		store, err = openBoltStore(cfg.StorePath)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStoreType, cfg.StoreType)
+	}
+
+	backend := newSMTPBackendWithStore(store)
+	backend.retention = newRetentionPolicy(cfg)
+	backend.maxMessageBytes = cfg.SMTPMaxMessageBytes
+	// A persistent store may hold more than the limits allow.
+	if err := backend.sweep(time.Now()); err != nil {
+		_ = store.Close()
+
+		return nil, err
+	}
+
+	sharedBackend = backend
+
+	return store, nil
+}
+
+// memoryStore keeps messages in a messageIndex only. It is the default store.
+type memoryStore struct {
+	mux   sync.RWMutex
+	index *messageIndex
+
+	// notify is closed and replaced whenever the stored messages change.
+	notify chan struct{}
+}
+
+var _ Store = (*memoryStore)(nil)
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{index: newMessageIndex()}
+}
+
+func (s *memoryStore) Append(msg *smtpMessage) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.index.get(msg.id); ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMessageID, msg.id)
+	}
+
+	s.index.add(msg)
+	s.notifyLocked()
+
+	return nil
+}
+
+func (s *memoryStore) Get(id string) (*smtpMessage, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.get(id)
+}
+
+func (s *memoryStore) List() []*smtpMessage {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.all()
+}
+
+func (s *memoryStore) Oldest() (*smtpMessage, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.oldest()
+}
+
+func (s *memoryStore) ReceivedBefore(t time.Time) []*smtpMessage {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.receivedBefore(t)
+}
+
+func (s *memoryStore) Search(field, email string) []*smtpMessage {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.search(field, email)
+}
+
+func (s *memoryStore) SearchText(terms []string) []textHit {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.searchText(terms)
+}
+
+func (s *memoryStore) Delete(id string) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.index.remove(id) == nil {
+		return false, nil
+	}
+	s.notifyLocked()
+
+	return true, nil
+}
+
+func (s *memoryStore) DeleteAll() (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	n := s.index.len()
+	s.index = newMessageIndex()
+	s.notifyLocked()
+
+	return n, nil
+}
+
+func (s *memoryStore) Replace(messages []*smtpMessage) error {
+	if err := checkUniqueIDs(messages); err != nil {
+		return err
+	}
+
+	// Build the new index before taking the lock, readers keep seeing the old one meanwhile.
+	index := newMessageIndex()
+	for _, msg := range messages {
+		index.add(msg)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.index = index
+	s.notifyLocked()
+
+	return nil
+}
+
+func (s *memoryStore) Len() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.len()
+}
+
+func (s *memoryStore) Size() int64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.index.bytes()
+}
+
+func (s *memoryStore) Subscribe() <-chan struct{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.notify == nil {
+		s.notify = make(chan struct{})
+	}
+
+	return s.notify
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// checkUniqueIDs reports the first ID that appears more than once in messages.
+func checkUniqueIDs(messages []*smtpMessage) error {
+	seen := make(map[string]struct{}, len(messages))
+	for _, msg := range messages {
+		if _, ok := seen[msg.id]; ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateMessageID, msg.id)
+		}
+		seen[msg.id] = struct{}{}
+	}
+
+	return nil
+}
+
+// notifyLocked wakes every subscriber. s.mux must be held for writing.
+func (s *memoryStore) notifyLocked() {
+	if s.notify != nil {
+		close(s.notify)
+		s.notify = nil
+	}
+}
+
+type (
+	// storedMessage is the serialized form of an smtpMessage used by persistent stores and
+	// snapshot dumps. The view is rebuilt on load.
+	storedMessage struct {
+		ID           string              `json:"id"`
+		Data         string              `json:"data"`
+		ReceivedTime time.Time           `json:"receivedTime"`
+		MailFrom     string              `json:"mailFrom"`
+		MailOpts     *smtp.MailOptions   `json:"mailOpts"`
+		RcptTo       []string            `json:"rcptTo"`
+		RcptOpts     []*smtp.RcptOptions `json:"rcptOpts"`
+		Conn         storedConnection    `json:"conn"`
+	}
+
+	storedConnection struct {
+		ID               uint64    `json:"id"`
+		ConnectedTime    time.Time `json:"connectedTime"`
+		Listener         string    `json:"listener,omitempty"`
+		ClientAddr       string    `json:"clientAddr"`
+		ClientHost       string    `json:"clientHost"`
+		TLSUsed          bool      `json:"tlsUsed"`
+		TLSVersion       string    `json:"tlsVersion,omitempty"`
+		TLSCipherSuite   string    `json:"tlsCipherSuite,omitempty"`
+		TLSServerName    string    `json:"tlsServerName,omitempty"`
+		TLSClientSubject string    `json:"tlsClientSubject,omitempty"`
+		Authenticated    bool      `json:"authenticated"`
+		AuthMechanism    string    `json:"authMechanism"`
+		AuthUser         string    `json:"authUser,omitempty"`
+		AuthPassword     string    `json:"authPassword,omitempty"`
+	}
+)
+
+func newStoredMessage(msg *smtpMessage) storedMessage {
+	return storedMessage{
+		ID:           msg.id,
+		Data:         msg.data,
+		ReceivedTime: msg.receivedTime,
+		MailFrom:     msg.mailFrom,
+		MailOpts:     msg.mailOpts,
+		RcptTo:       msg.rcptTo,
+		RcptOpts:     msg.rcptOpts,
+		Conn: storedConnection{
+			ID:               msg.conn.id,
+			ConnectedTime:    msg.conn.connectedTime,
+			Listener:         msg.conn.listener,
+			ClientAddr:       msg.conn.clientAddr,
+			ClientHost:       msg.conn.clientHost,
+			TLSUsed:          msg.conn.tlsUsed,
+			TLSVersion:       msg.conn.tlsVersion,
+			TLSCipherSuite:   msg.conn.tlsCipherSuite,
+			TLSServerName:    msg.conn.tlsServerName,
+			TLSClientSubject: msg.conn.tlsClientSubject,
+			Authenticated:    msg.conn.authenticated,
+			AuthMechanism:    msg.conn.authMechanism,
+			AuthUser:         msg.conn.authUser,
+			AuthPassword:     msg.conn.authPassword,
+		},
+	}
+}
+
+func (m storedMessage) message() *smtpMessage {
+	return &smtpMessage{
+		id:           m.ID,
+		data:         m.Data,
+		receivedTime: m.ReceivedTime,
+		mailFrom:     m.MailFrom,
+		mailOpts:     m.MailOpts,
+		rcptTo:       m.RcptTo,
+		rcptOpts:     m.RcptOpts,
+		conn: &smtpConnection{
+			id:               m.Conn.ID,
+			connectedTime:    m.Conn.ConnectedTime,
+			listener:         m.Conn.Listener,
+			clientAddr:       m.Conn.ClientAddr,
+			clientHost:       m.Conn.ClientHost,
+			tlsUsed:          m.Conn.TLSUsed,
+			tlsVersion:       m.Conn.TLSVersion,
+			tlsCipherSuite:   m.Conn.TLSCipherSuite,
+			tlsServerName:    m.Conn.TLSServerName,
+			tlsClientSubject: m.Conn.TLSClientSubject,
+			authenticated:    m.Conn.Authenticated,
+			authMechanism:    m.Conn.AuthMechanism,
+			authUser:         m.Conn.AuthUser,
+			authPassword:     m.Conn.AuthPassword,
+		},
+	}
+}
@@ -0,0 +1,215 @@
+package fakesmtpserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltMessagesBucket holds one record per message keyed by its big-endian arrival sequence number.
+var boltMessagesBucket = []byte("messages") //nolint:gochecknoglobals
+
+const (
+	boltFileMode    = 0o600
+	boltOpenTimeout = 5 * time.Second
+)
+
+// boltStore persists messages to a bbolt database file.
+// Reads are served from an in-memory index that is rebuilt from the file on open.
+type boltStore struct {
+	*memoryStore
+
+	// mux serializes writes so the file and the index never disagree.
+	mux  sync.Mutex
+	db   *bolt.DB
+	keys map[string][]byte // Message ID to record key
+}
+
+var _ Store = (*boltStore)(nil)
+
+// openBoltStore opens or creates the database at path and loads every message in it.
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, boltFileMode, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt store: %w", err)
+	}
+
+	s := &boltStore{
+		memoryStore: newMemoryStore(),
+		db:          db,
+		keys:        make(map[string][]byte),
+	}
+
+	if err := s.load(); err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load creates the messages bucket if needed and indexes every stored message.
+func (s *boltStore) load() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(boltMessagesBucket)
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var record storedMessage
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("decode message %x: %w", k, err)
+			}
+
+			s.keys[record.ID] = k
+			s.index.add(record.message())
+
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("load bolt store: %w", err)
+	}
+
+	return nil
+}
+
+func (s *boltStore) Append(msg *smtpMessage) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.keys[msg.id]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMessageID, msg.id)
+	}
+
+	var key []byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		key, err = putBoltMessage(tx.Bucket(boltMessagesBucket), msg)
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("append message: %w", err)
+	}
+
+	s.keys[msg.id] = key
+
+	return s.memoryStore.Append(msg)
+}
+
+func (s *boltStore) Delete(id string) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return false, nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMessagesBucket).Delete(key)
+	})
+	if err != nil {
+		return false, fmt.Errorf("delete message: %w", err)
+	}
+
+	delete(s.keys, id)
+
+	return s.memoryStore.Delete(id)
+}
+
+func (s *boltStore) DeleteAll() (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltMessagesBucket); err != nil {
+			return fmt.Errorf("delete bucket: %w", err)
+		}
+		if _, err := tx.CreateBucket(boltMessagesBucket); err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("delete all messages: %w", err)
+	}
+
+	clear(s.keys)
+
+	return s.memoryStore.DeleteAll()
+}
+
+// Replace swaps the whole file content in one transaction.
+func (s *boltStore) Replace(messages []*smtpMessage) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := checkUniqueIDs(messages); err != nil {
+		return err
+	}
+
+	keys := make(map[string][]byte, len(messages))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltMessagesBucket); err != nil {
+			return fmt.Errorf("delete bucket: %w", err)
+		}
+		bucket, err := tx.CreateBucket(boltMessagesBucket)
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+
+		for _, msg := range messages {
+			key, err := putBoltMessage(bucket, msg)
+			if err != nil {
+				return err
+			}
+			keys[msg.id] = key
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("replace messages: %w", err)
+	}
+
+	s.keys = keys
+
+	return s.memoryStore.Replace(messages)
+}
+
+func (s *boltStore) Close() error {
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("close bolt store: %w", err)
+	}
+
+	return nil
+}
+
+// putBoltMessage stores msg under the next sequence number of bucket and returns its key.
+func putBoltMessage(bucket *bolt.Bucket, msg *smtpMessage) ([]byte, error) {
+	v, err := json.Marshal(newStoredMessage(msg))
+	if err != nil {
+		return nil, fmt.Errorf("encode message: %w", err)
+	}
+
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return nil, fmt.Errorf("next sequence: %w", err)
+	}
+	key := binary.BigEndian.AppendUint64(nil, seq)
+
+	if err := bucket.Put(key, v); err != nil {
+		return nil, fmt.Errorf("put message: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,402 @@
+package fakesmtpserver
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStoreConformance(t, func(*testing.T) Store {
+		return newMemoryStore()
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		t.Helper()
+
+		return openTestBoltStore(t, filepath.Join(t.TempDir(), "messages.db"))
+	})
+}
+
+// testStoreConformance runs the behavior every Store implementation must share.
+func testStoreConformance(t *testing.T, open func(t *testing.T) Store) {
+	t.Helper()
+
+	t.Run("append_get_list", func(t *testing.T) {
+		store := open(t)
+		msgs := appendStoreTestMessages(t, store)
+
+		if store.Len() != len(msgs) {
+			t.Errorf("Len() = %d, want %d", store.Len(), len(msgs))
+		}
+		var size int64
+		for _, msg := range msgs {
+			size += int64(len(msg.data))
+		}
+		if store.Size() != size {
+			t.Errorf("Size() = %d, want %d", store.Size(), size)
+		}
+		if got := storeMessageIDs(store.List()); !slices.Equal(got, []string{"a", "b", "c"}) {
+			t.Errorf("List() = %v, want arrival order", got)
+		}
+		if oldest, ok := store.Oldest(); !ok || oldest.id != "a" {
+			t.Errorf("Oldest() = %v, %v, want a", oldest, ok)
+		}
+
+		msg, ok := store.Get("b")
+		if !ok {
+			t.Fatal("Get() did not find the stored message")
+		}
+		if msg.view == nil || msg.view.Subject != "Second" {
+			t.Errorf("Get() returned an unparsed or wrong message: %+v", msg.view)
+		}
+
+		if _, ok := store.Get("missing"); ok {
+			t.Error("Get() found a message that was never stored")
+		}
+	})
+
+	t.Run("append_duplicate_id", func(t *testing.T) {
+		store := open(t)
+		appendStoreTestMessages(t, store)
+
+		err := store.Append(newStoreTestMessage("a", "to@example.com", "Again"))
+		if !errors.Is(err, ErrDuplicateMessageID) {
+			t.Errorf("Append() error = %v, want %v", err, ErrDuplicateMessageID)
+		}
+		if store.Len() != 3 {
+			t.Errorf("Len() = %d after a rejected append, want 3", store.Len())
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		store := open(t)
+		appendStoreTestMessages(t, store)
+
+		if got := storeMessageIDs(store.Search(FieldTo, "TO@example.com")); !slices.Equal(got, []string{"a", "c"}) {
+			t.Errorf("Search(to) = %v, want [a c]", got)
+		}
+		if got := storeMessageIDs(store.Search(FieldFrom, "sender@example.com")); len(got) != 3 {
+			t.Errorf("Search(from) = %v, want every message", got)
+		}
+		if got := store.Search(FieldCC, "to@example.com"); len(got) != 0 {
+			t.Errorf("Search(cc) = %v, want none", storeMessageIDs(got))
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		store := open(t)
+		appendStoreTestMessages(t, store)
+
+		ok, err := store.Delete("a")
+		if err != nil || !ok {
+			t.Fatalf("Delete() = %v, %v, want true, nil", ok, err)
+		}
+		ok, err = store.Delete("a")
+		if err != nil || ok {
+			t.Errorf("second Delete() = %v, %v, want false, nil", ok, err)
+		}
+
+		if got := storeMessageIDs(store.List()); !slices.Equal(got, []string{"b", "c"}) {
+			t.Errorf("List() = %v, want [b c]", got)
+		}
+		if oldest, ok := store.Oldest(); !ok || oldest.id != "b" {
+			t.Errorf("Oldest() = %v, %v, want b", oldest, ok)
+		}
+		if got := storeMessageIDs(store.Search(FieldTo, "to@example.com")); !slices.Equal(got, []string{"c"}) {
+			t.Errorf("Search(to) = %v, want [c]", got)
+		}
+
+		// A deleted ID can be stored again
+		if err := store.Append(newStoreTestMessage("a", "to@example.com", "Again")); err != nil {
+			t.Errorf("Append() after Delete() error = %v", err)
+		}
+	})
+
+	t.Run("received_before", func(t *testing.T) {
+		testStoreReceivedBefore(t, open(t))
+	})
+
+	t.Run("delete_all", func(t *testing.T) {
+		store := open(t)
+		appendStoreTestMessages(t, store)
+
+		n, err := store.DeleteAll()
+		if err != nil || n != 3 {
+			t.Fatalf("DeleteAll() = %d, %v, want 3, nil", n, err)
+		}
+		if store.Len() != 0 || store.Size() != 0 || len(store.List()) != 0 || len(store.Search(FieldTo, "to@example.com")) != 0 {
+			t.Error("store should be empty after DeleteAll()")
+		}
+		if _, ok := store.Oldest(); ok {
+			t.Error("Oldest() found a message in an empty store")
+		}
+
+		if err := store.Append(newStoreTestMessage("d", "to@example.com", "Fourth")); err != nil {
+			t.Fatalf("Append() after DeleteAll() error = %v", err)
+		}
+		if got := storeMessageIDs(store.List()); !slices.Equal(got, []string{"d"}) {
+			t.Errorf("List() = %v, want [d]", got)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		testStoreReplace(t, open(t))
+	})
+
+	t.Run("subscribe", func(t *testing.T) {
+		store := open(t)
+
+		ch := store.Subscribe()
+		assertStoreNotified(t, ch, false)
+
+		if err := store.Append(newStoreTestMessage("a", "to@example.com", "First")); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		assertStoreNotified(t, ch, true)
+
+		ch = store.Subscribe()
+		if _, err := store.Delete("missing"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		assertStoreNotified(t, ch, false)
+
+		if _, err := store.Delete("a"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		assertStoreNotified(t, ch, true)
+
+		ch = store.Subscribe()
+		if _, err := store.DeleteAll(); err != nil {
+			t.Fatalf("DeleteAll() error = %v", err)
+		}
+		assertStoreNotified(t, ch, true)
+	})
+}
+
+// testStoreReplace checks that Replace swaps every message and its index, and rejects duplicate IDs.
+func testStoreReceivedBefore(t *testing.T, store Store) {
+	t.Helper()
+
+	late := newStoreTestMessage("late", "to@example.com", "Late")
+	late.receivedTime = late.receivedTime.Add(time.Hour)
+	early := newStoreTestMessage("early", "to@example.com", "Early")
+	for _, msg := range []*smtpMessage{late, early} {
+		if err := store.Append(msg); err != nil {
+			t.Fatalf("Append(%s) error = %v", msg.id, err)
+		}
+	}
+
+	// The earliest receive time is not the first arrival
+	if got := storeMessageIDs(store.ReceivedBefore(early.receivedTime.Add(time.Minute))); !slices.Equal(got, []string{"early"}) {
+		t.Errorf("ReceivedBefore() = %v, want [early]", got)
+	}
+	if got := store.ReceivedBefore(early.receivedTime); len(got) != 0 {
+		t.Errorf("ReceivedBefore() = %v, want none", storeMessageIDs(got))
+	}
+}
+
+func testStoreReplace(t *testing.T, store Store) {
+	t.Helper()
+
+	appendStoreTestMessages(t, store)
+
+	ch := store.Subscribe()
+	replacement := []*smtpMessage{
+		newStoreTestMessage("c", "other@example.com", "Replaced"),
+		newStoreTestMessage("x", "to@example.com", "New"),
+	}
+	if err := store.Replace(replacement); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	assertStoreNotified(t, ch, true)
+
+	if got := storeMessageIDs(store.List()); !slices.Equal(got, []string{"c", "x"}) {
+		t.Errorf("List() = %v, want [c x]", got)
+	}
+	if got := storeMessageIDs(store.Search(FieldTo, "to@example.com")); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("Search(to) = %v, want [x]", got)
+	}
+	if msg, _ := store.Get("c"); msg.view.Subject != "Replaced" {
+		t.Errorf("Get(c) subject = %q, want Replaced", msg.view.Subject)
+	}
+
+	err := store.Replace([]*smtpMessage{replacement[0], replacement[0]})
+	if !errors.Is(err, ErrDuplicateMessageID) {
+		t.Errorf("Replace() with duplicate IDs error = %v, want %v", err, ErrDuplicateMessageID)
+	}
+	if store.Len() != 2 {
+		t.Errorf("Len() = %d after a rejected replace, want 2", store.Len())
+	}
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+
+	store, err := openBoltStore(path)
+	if err != nil {
+		t.Fatalf("openBoltStore() error = %v", err)
+	}
+	appendStoreTestMessages(t, store)
+
+	authOpt := "<sender@example.com>"
+	msg := newStoreTestMessage("d", "other@example.com", "Fourth")
+	msg.mailOpts = &smtp.MailOptions{Size: 42, Auth: &authOpt}
+	msg.rcptOpts = []*smtp.RcptOptions{{Notify: []smtp.DSNNotify{smtp.DSNNotifyFailure}}}
+	msg.conn = &smtpConnection{
+		id: 7, listener: ListenerSMTPS, clientAddr: "192.0.2.1:2525", clientHost: "client.example.com",
+		tlsUsed: true, tlsVersion: "TLS 1.3", tlsCipherSuite: "TLS_AES_128_GCM_SHA256", tlsServerName: "mail.example.test",
+	}
+	if err := store.Append(msg); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if _, err := store.Delete("b"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened := openTestBoltStore(t, path)
+	if got := storeMessageIDs(reopened.List()); !slices.Equal(got, []string{"a", "c", "d"}) {
+		t.Fatalf("List() after reopen = %v, want [a c d]", got)
+	}
+	if got := storeMessageIDs(reopened.Search(FieldTo, "other@example.com")); !slices.Equal(got, []string{"d"}) {
+		t.Errorf("Search(to) after reopen = %v, want [d]", got)
+	}
+
+	got, _ := reopened.Get("d")
+	if got.view.Subject != "Fourth" || !got.receivedTime.Equal(msg.receivedTime) {
+		t.Errorf("reopened message = %q at %v, want %q at %v", got.view.Subject, got.receivedTime, "Fourth", msg.receivedTime)
+	}
+	if got.mailOpts.Size != 42 || *got.mailOpts.Auth != authOpt || got.rcptOpts[0].Notify[0] != smtp.DSNNotifyFailure {
+		t.Errorf("reopened options = %+v %+v", got.mailOpts, got.rcptOpts[0])
+	}
+	if *got.conn != *msg.conn {
+		t.Errorf("reopened connection = %+v, want %+v", got.conn, msg.conn)
+	}
+
+	// Connection IDs continue after the stored ones
+	backend := newSMTPBackendWithStore(reopened)
+	if backend.connSeq.Load() != 7 {
+		t.Errorf("connSeq = %d, want 7", backend.connSeq.Load())
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	originalBackend := sharedBackend
+	defer func() { sharedBackend = originalBackend }()
+
+	tests := []struct {
+		name      string
+		storeType string
+		wantErr   error
+	}{
+		{name: "memory", storeType: StoreTypeMemory},
+		{name: "bolt", storeType: StoreTypeBolt},
+		{name: "unknown", storeType: "redis", wantErr: ErrUnknownStoreType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sharedBackend = originalBackend
+
+			cfg := &config.Config{StoreType: tt.storeType, StorePath: filepath.Join(t.TempDir(), "messages.db")}
+			store, err := OpenStore(cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenStore() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if sharedBackend != originalBackend {
+					t.Error("OpenStore() replaced the backend despite failing")
+				}
+
+				return
+			}
+			defer store.Close()
+
+			if sharedBackend.store != store {
+				t.Error("OpenStore() did not install the opened store")
+			}
+		})
+	}
+}
+
+func openTestBoltStore(t *testing.T, path string) *boltStore {
+	t.Helper()
+
+	store, err := openBoltStore(path)
+	if err != nil {
+		t.Fatalf("openBoltStore() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	})
+
+	return store
+}
+
+func newStoreTestMessage(id, to, subject string) *smtpMessage {
+	msg := &smtpMessage{
+		id:           id,
+		data:         createTestEmailData("sender@example.com", to, subject),
+		receivedTime: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		mailFrom:     "sender@example.com",
+		rcptTo:       []string{to},
+		conn:         &smtpConnection{},
+	}
+	msg.parse()
+
+	return msg
+}
+
+// appendStoreTestMessages stores messages a, b and c; a and c are sent to to@example.com.
+func appendStoreTestMessages(t *testing.T, store Store) []*smtpMessage {
+	t.Helper()
+
+	msgs := []*smtpMessage{
+		newStoreTestMessage("a", "to@example.com", "First"),
+		newStoreTestMessage("b", "other@example.com", "Second"),
+		newStoreTestMessage("c", "to@example.com", "Third"),
+	}
+	for _, msg := range msgs {
+		if err := store.Append(msg); err != nil {
+			t.Fatalf("Append(%s) error = %v", msg.id, err)
+		}
+	}
+
+	return msgs
+}
+
+func storeMessageIDs(messages []*smtpMessage) []string {
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.id
+	}
+
+	return ids
+}
+
+func assertStoreNotified(t *testing.T, ch <-chan struct{}, want bool) {
+	t.Helper()
+
+	select {
+	case <-ch:
+		if !want {
+			t.Error("subscription fired without a change")
+		}
+	default:
+		if want {
+			t.Error("subscription did not fire after a change")
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package fakesmtpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+// ErrIncompleteTLSConfig is returned when only one of the certificate and key files is configured.
+var ErrIncompleteTLSConfig = errors.New("SMTP_TLS_CERT_FILE and SMTP_TLS_KEY_FILE must be set together")
+
+const (
+	// generatedCertValidity is how long the generated CA and server certificates are valid.
+	generatedCertValidity = 365 * 24 * time.Hour
+	// generatedCertBackdate protects the generated certificates against clock skew of clients.
+	generatedCertBackdate = time.Hour
+)
+
+// smtpTLS is the TLS setup of the SMTP listeners.
+type smtpTLS struct {
+	config *tls.Config
+	// caPEM is the CA that issued the generated server certificate, nil for a configured certificate.
+	caPEM []byte
+}
+
+// ConfigureTLS sets up TLS and the TLS policy on the shared backend when STARTTLS or the SMTPS listener
+// is enabled, using the configured certificate or, without one, a certificate issued by a CA generated for this process.
+// It must be called before the SMTP servers are started.
+func ConfigureTLS(cfg *config.Config) error {
+	policy, err := newTLSPolicy(cfg)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.SMTPStartTLS && cfg.SMTPSAddr == "" {
+		if policy.enabled() {
+			return ErrTLSPolicyWithoutTLS
+		}
+
+		return nil
+	}
+
+	t, err := newSMTPTLS(cfg, time.Now())
+	if err != nil {
+		return err
+	}
+	policy.apply(t.config)
+	sharedBackend.tls = t
+	sharedBackend.tlsPolicy = policy
+
+	return nil
+}
+
+func newSMTPTLS(cfg *config.Config, now time.Time) (*smtpTLS, error) {
+	t := &smtpTLS{}
+
+	var cert tls.Certificate
+	switch {
+	case cfg.SMTPTLSCertFile != "" && cfg.SMTPTLSKeyFile != "":
+		var err error
+		cert, err = tls.LoadX509KeyPair(cfg.SMTPTLSCertFile, cfg.SMTPTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls certificate: %w", err)
+		}
+	case cfg.SMTPTLSCertFile != "" || cfg.SMTPTLSKeyFile != "":
+		return nil, ErrIncompleteTLSConfig
+	default:
+		var err error
+		cert, t.caPEM, err = generateCertificates(certificateHosts(cfg), now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.config = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return t, nil
+}
+
+// certificateHosts returns the names and IPs the generated server certificate is valid for.
+func certificateHosts(cfg *config.Config) []string {
+	hosts := []string{cfg.SMTPHostname, "localhost", "127.0.0.1", "::1"}
+	for _, addr := range []string{cfg.SMTPAddr, cfg.SMTPSAddr} {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			hosts = append(hosts, host)
+		}
+	}
+	hosts = append(hosts, cfg.SMTPTLSHosts...)
+
+	// Wildcard listen addresses are no names.
+	hosts = slices.DeleteFunc(hosts, func(h string) bool {
+		ip := net.ParseIP(h)
+
+		return h == "" || (ip != nil && ip.IsUnspecified())
+	})
+	slices.Sort(hosts)
+
+	return slices.Compact(hosts)
+}
+
+// generateCertificates creates a CA and a server certificate for hosts issued by it.
+// It returns the server certificate, chained to the CA, and the CA certificate as PEM.
+func generateCertificates(hosts []string, now time.Time) (tls.Certificate, []byte, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("generate ca key: %w", err)
+	}
+
+	caTemplate, err := newCertificateTemplate(now)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caTemplate.Subject = pkix.Name{Organization: []string{"go-fake-smtp-server"}, CommonName: "go-fake-smtp-server CA"}
+	caTemplate.IsCA = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("create ca certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("parse ca certificate: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("generate server key: %w", err)
+	}
+
+	template, err := newCertificateTemplate(now)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template.Subject = pkix.Name{Organization: []string{"go-fake-smtp-server"}, CommonName: hosts[0]}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("create server certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("parse server certificate: %w", err)
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der, caDER},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), nil
+}
+
+func newCertificateTemplate(now time.Time) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial number: %w", err)
+	}
+
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		NotBefore:             now.Add(-generatedCertBackdate),
+		NotAfter:              now.Add(generatedCertValidity),
+		BasicConstraintsValid: true,
+	}, nil
+}
+
+// setTLS records the negotiated parameters of state on conn.
+func (conn *smtpConnection) setTLS(state tls.ConnectionState) {
+	conn.tlsUsed = true
+	conn.tlsVersion = tls.VersionName(state.Version)
+	conn.tlsCipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	conn.tlsServerName = state.ServerName
+	if len(state.PeerCertificates) > 0 {
+		conn.tlsClientSubject = state.PeerCertificates[0].Subject.String()
+	}
+	conn.tlsState = &state
+}
@@ -0,0 +1,256 @@
+package fakesmtpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+func testTLSConfig() *config.Config {
+	return &config.Config{
+		SMTPAddr:     "0.0.0.0:10025",
+		SMTPHostname: "mail.example.test",
+		SMTPStartTLS: true,
+		SMTPTLSHosts: []string{"smtp.internal", "10.0.0.5"},
+	}
+}
+
+func TestGeneratedCertificate(t *testing.T) {
+	now := time.Now()
+	st, err := newSMTPTLS(testTLSConfig(), now)
+	if err != nil {
+		t.Fatalf("newSMTPTLS() error = %v", err)
+	}
+
+	block, _ := pem.Decode(st.caPEM)
+	if block == nil {
+		t.Fatal("caPEM is not PEM encoded")
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse CA: %v", err)
+	}
+	if !ca.IsCA {
+		t.Error("generated CA is not a CA")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	leaf := st.config.Certificates[0].Leaf
+	for _, name := range []string{"mail.example.test", "localhost", "127.0.0.1", "::1", "smtp.internal", "10.0.0.5"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots, CurrentTime: now}); err != nil {
+			t.Errorf("leaf does not verify for %s: %v", name, err)
+		}
+	}
+	if slices.ContainsFunc(leaf.IPAddresses, func(ip net.IP) bool { return ip.IsUnspecified() }) {
+		t.Errorf("leaf contains the wildcard listen address: %v", leaf.IPAddresses)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "other.example", Roots: roots, CurrentTime: now}); err == nil {
+		t.Error("leaf verifies for a name it was not issued for")
+	}
+}
+
+func TestConfiguredCertificate(t *testing.T) {
+	generated, err := newSMTPTLS(testTLSConfig(), time.Now())
+	if err != nil {
+		t.Fatalf("newSMTPTLS() error = %v", err)
+	}
+	cert := generated.config.Certificates[0]
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testTLSConfig()
+	cfg.SMTPTLSCertFile = certFile
+	cfg.SMTPTLSKeyFile = keyFile
+	st, err := newSMTPTLS(cfg, time.Now())
+	if err != nil {
+		t.Fatalf("newSMTPTLS() error = %v", err)
+	}
+	if st.caPEM != nil {
+		t.Error("caPEM is set for a configured certificate")
+	}
+	if got := st.config.Certificates[0].Certificate[0]; !slices.Equal(got, cert.Certificate[0]) {
+		t.Error("configured certificate was not loaded")
+	}
+
+	cfg.SMTPTLSKeyFile = ""
+	if _, err := newSMTPTLS(cfg, time.Now()); !errors.Is(err, ErrIncompleteTLSConfig) {
+		t.Errorf("newSMTPTLS() without key error = %v, want %v", err, ErrIncompleteTLSConfig)
+	}
+
+	cfg.SMTPTLSKeyFile = filepath.Join(dir, "missing.pem")
+	if _, err := newSMTPTLS(cfg, time.Now()); err == nil {
+		t.Error("newSMTPTLS() with a missing key file should fail")
+	}
+}
+
+func TestSTARTTLS(t *testing.T) {
+	cfg := testTLSConfig()
+	st, err := newSMTPTLS(cfg, time.Now())
+	if err != nil {
+		t.Fatalf("newSMTPTLS() error = %v", err)
+	}
+	backend := newSMTPBackend()
+	backend.tls = st
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serveTestSMTP(t, newSMTPServer(cfg, backend), l)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(st.caPEM)
+
+	c, err := smtp.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		t.Fatal("STARTTLS is not advertised")
+	}
+	if err := c.StartTLS(&tls.Config{ServerName: "mail.example.test", RootCAs: roots, MinVersion: tls.VersionTLS13}); err != nil {
+		t.Fatalf("StartTLS() error = %v", err)
+	}
+	sendTestMail(t, c, "Secure")
+
+	msgs := backend.store.List()
+	if len(msgs) != 1 {
+		t.Fatalf("stored %d messages, want 1", len(msgs))
+	}
+	view := msgs[0].view
+	if view.Listener != ListenerSMTP {
+		t.Errorf("Listener = %q, want %q", view.Listener, ListenerSMTP)
+	}
+	if !view.TLSUsed || view.TLSVersion != "TLS 1.3" || view.TLSCipherSuite == "" || view.TLSServerName != "mail.example.test" {
+		t.Errorf("TLS fields = %v %q %q %q, want TLS 1.3 with SNI mail.example.test",
+			view.TLSUsed, view.TLSVersion, view.TLSCipherSuite, view.TLSServerName)
+	}
+}
+
+func TestSTARTTLSDisabled(t *testing.T) {
+	cfg := testTLSConfig()
+	cfg.SMTPStartTLS = false
+
+	if s := newSMTPServer(cfg, newSMTPBackend()); s.TLSConfig != nil {
+		t.Error("TLSConfig is set although STARTTLS is disabled")
+	}
+}
+
+func TestSMTPS(t *testing.T) {
+	cfg := testTLSConfig()
+	cfg.SMTPStartTLS = false
+	st, err := newSMTPTLS(cfg, time.Now())
+	if err != nil {
+		t.Fatalf("newSMTPTLS() error = %v", err)
+	}
+	backend := newSMTPBackend()
+	backend.tls = st
+
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serveTestSMTP(t, newSMTPServer(cfg, backend), plain)
+
+	smtps := newSMTPSServer(cfg, backend)
+	implicit, err := tls.Listen("tcp", "127.0.0.1:0", smtps.TLSConfig)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serveTestSMTP(t, smtps, implicit)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(st.caPEM)
+	conn, err := tls.Dial("tcp", implicit.Addr().String(), &tls.Config{ServerName: "localhost", RootCAs: roots, MinVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	c, err := smtp.NewClient(conn, "localhost")
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		t.Error("STARTTLS is advertised on the implicit TLS listener")
+	}
+	sendTestMail(t, c, "Implicit")
+
+	c2, err := smtp.Dial(plain.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c2.Close()
+	if ok, _ := c2.Extension("STARTTLS"); ok {
+		t.Error("STARTTLS is advertised although only SMTPS is enabled")
+	}
+	sendTestMail(t, c2, "Plain")
+
+	got := map[string]*smtpView{}
+	for _, msg := range backend.store.List() {
+		got[msg.view.Subject] = msg.view
+	}
+	if v := got["Implicit"]; v == nil || v.Listener != ListenerSMTPS || !v.TLSUsed || v.TLSServerName != "localhost" {
+		t.Errorf("implicit TLS message = %+v, want listener %s with TLS", v, ListenerSMTPS)
+	}
+	if v := got["Plain"]; v == nil || v.Listener != ListenerSMTP || v.TLSUsed {
+		t.Errorf("plaintext message = %+v, want listener %s without TLS", v, ListenerSMTP)
+	}
+}
+
+// serveTestSMTP serves s on l until the test ends.
+func serveTestSMTP(t *testing.T, s *gosmtp.Server, l net.Listener) {
+	t.Helper()
+
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+}
+
+// sendTestMail sends one message with subject over c and quits.
+func sendTestMail(t *testing.T, c *smtp.Client, subject string) {
+	t.Helper()
+
+	if err := c.Mail("sender@example.com"); err != nil {
+		t.Fatalf("Mail() error = %v", err)
+	}
+	if err := c.Rcpt("recipient@example.com"); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	wc, err := c.Data()
+	if err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	if _, err := wc.Write([]byte(createTestEmailData("sender@example.com", "recipient@example.com", subject))); err != nil {
+		t.Fatalf("failed to write data: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		t.Fatalf("failed to close data: %v", err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit() error = %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package fakesmtpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/emersion/go-smtp"
+	"github.com/sters/go-fake-smtp-server/config"
+)
+
+var (
+	// ErrInvalidTLSMinVersion is returned when SMTP_TLS_MIN_VERSION is not a supported version.
+	ErrInvalidTLSMinVersion = errors.New("invalid SMTP_TLS_MIN_VERSION: must be 1.2 or 1.3")
+	// ErrInvalidTLSClientAuth is returned when SMTP_TLS_CLIENT_AUTH is not a supported mode.
+	ErrInvalidTLSClientAuth = errors.New("invalid SMTP_TLS_CLIENT_AUTH: must be none, request, verify or require")
+	// ErrMissingTLSClientCA is returned when client certificates are verified without SMTP_TLS_CLIENT_CA_FILE.
+	ErrMissingTLSClientCA = errors.New("SMTP_TLS_CLIENT_CA_FILE is required to verify client certificates")
+	// ErrTLSPolicyWithoutTLS is returned when a TLS policy is configured but neither STARTTLS nor SMTPS is enabled.
+	ErrTLSPolicyWithoutTLS = errors.New("TLS policy requires SMTP_STARTTLS or SMTPS_ADDR")
+)
+
+const (
+	// Client certificate modes of SMTP_TLS_CLIENT_AUTH.
+	TLSClientAuthNone    = "none"    // Client certificates are not asked for
+	TLSClientAuthRequest = "request" // Asked for and recorded, never refused
+	TLSClientAuthVerify  = "verify"  // Certificates that do not verify are refused
+	TLSClientAuthRequire = "require" // Like verify, and mail without a certificate is refused
+)
+
+var tlsMinVersions = map[string]uint16{ //nolint:gochecknoglobals
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsPolicy decides whether a connection may send mail. It is checked on MAIL FROM,
+// so clients see an SMTP error instead of a failed handshake.
+type tlsPolicy struct {
+	requireTLS bool
+	minVersion uint16 // 0 accepts any version
+	clientAuth string
+	clientCAs  *x509.CertPool
+}
+
+func newTLSPolicy(cfg *config.Config) (tlsPolicy, error) {
+	p := tlsPolicy{requireTLS: cfg.SMTPRequireTLS, clientAuth: cfg.SMTPTLSClientAuth}
+
+	if cfg.SMTPTLSMinVersion != "" {
+		v, ok := tlsMinVersions[cfg.SMTPTLSMinVersion]
+		if !ok {
+			return tlsPolicy{}, fmt.Errorf("%w: %s", ErrInvalidTLSMinVersion, cfg.SMTPTLSMinVersion)
+		}
+		p.minVersion = v
+	}
+
+	switch p.clientAuth {
+	case "":
+		p.clientAuth = TLSClientAuthNone
+	case TLSClientAuthNone, TLSClientAuthRequest:
+	case TLSClientAuthVerify, TLSClientAuthRequire:
+		if cfg.SMTPTLSClientCAFile == "" {
+			return tlsPolicy{}, ErrMissingTLSClientCA
+		}
+		data, err := os.ReadFile(cfg.SMTPTLSClientCAFile)
+		if err != nil {
+			return tlsPolicy{}, fmt.Errorf("read client ca: %w", err)
+		}
+		p.clientCAs = x509.NewCertPool()
+		if !p.clientCAs.AppendCertsFromPEM(data) {
+			return tlsPolicy{}, fmt.Errorf("%w: no certificates in %s", ErrMissingTLSClientCA, cfg.SMTPTLSClientCAFile)
+		}
+	default:
+		return tlsPolicy{}, fmt.Errorf("%w: %s", ErrInvalidTLSClientAuth, p.clientAuth)
+	}
+
+	return p, nil
+}
+
+func (p tlsPolicy) enabled() bool {
+	return p.requireTLS || p.minVersion != 0 || p.clientAuth != TLSClientAuthNone
+}
+
+// apply makes config ask for client certificates when the policy looks at them. Verification is
+// left to check, so an untrusted certificate is refused on MAIL FROM rather than in the handshake.
+func (p tlsPolicy) apply(config *tls.Config) {
+	if p.clientAuth != TLSClientAuthNone {
+		config.ClientAuth = tls.RequestClientCert
+	}
+}
+
+// check returns the SMTP error refusing mail on conn, or nil if the policy is met.
+func (p tlsPolicy) check(conn *smtpConnection) error {
+	state := conn.tlsState
+	if state == nil {
+		if p.requireTLS || p.minVersion != 0 || p.clientAuth == TLSClientAuthRequire {
+			return &smtp.SMTPError{
+				Code:         530,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+				Message:      "Must issue a STARTTLS command first",
+			}
+		}
+
+		return nil
+	}
+
+	if state.Version < p.minVersion {
+		return &smtp.SMTPError{
+			Code:         554,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+			Message:      tls.VersionName(p.minVersion) + " or later required, negotiated " + tls.VersionName(state.Version),
+		}
+	}
+
+	if p.clientAuth != TLSClientAuthVerify && p.clientAuth != TLSClientAuthRequire {
+		return nil
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		if p.clientAuth == TLSClientAuthRequire {
+			return &smtp.SMTPError{
+				Code:         530,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+				Message:      "Client certificate required",
+			}
+		}
+
+		return nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         p.clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return &smtp.SMTPError{
+			Code:         554,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+			Message:      "Client certificate not trusted",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package fakesmtpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+// testClientCA is a CA issuing client certificates in tests.
+type testClientCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestClientCA(t *testing.T) *testClientCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := newCertificateTemplate(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.Subject = pkix.Name{CommonName: "Test Client CA"}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testClientCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a client certificate for cn signed by ca.
+func (ca *testClientCA) issue(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := newCertificateTemplate(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.Subject = pkix.Name{Organization: []string{"Example"}, CommonName: cn}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeFile writes ca to a PEM file in a temporary directory and returns its path.
+func (ca *testClientCA) writeFile(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "client-ca.pem")
+	if err := os.WriteFile(path, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestNewTLSPolicy(t *testing.T) {
+	caFile := newTestClientCA(t).writeFile(t)
+
+	tests := []struct {
+		name       string
+		minVersion string
+		clientAuth string
+		caFile     string
+		wantErr    error
+	}{
+		{name: "defaults"},
+		{name: "min_version", minVersion: "1.3"},
+		{name: "invalid_min_version", minVersion: "1.1", wantErr: ErrInvalidTLSMinVersion},
+		{name: "request", clientAuth: TLSClientAuthRequest},
+		{name: "verify", clientAuth: TLSClientAuthVerify, caFile: caFile},
+		{name: "verify_without_ca", clientAuth: TLSClientAuthVerify, wantErr: ErrMissingTLSClientCA},
+		{name: "require_without_ca", clientAuth: TLSClientAuthRequire, wantErr: ErrMissingTLSClientCA},
+		{name: "invalid_client_auth", clientAuth: "optional", wantErr: ErrInvalidTLSClientAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testTLSConfig()
+			cfg.SMTPTLSMinVersion = tt.minVersion
+			cfg.SMTPTLSClientAuth = tt.clientAuth
+			cfg.SMTPTLSClientCAFile = tt.caFile
+
+			_, err := newTLSPolicy(cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("newTLSPolicy() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSPolicyCheck(t *testing.T) {
+	ca := newTestClientCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	trusted := ca.issue(t, "trusted").Leaf
+	untrusted := newTestClientCA(t).issue(t, "untrusted").Leaf
+
+	tlsConn := func(version uint16, certs ...*x509.Certificate) *smtpConnection {
+		return &smtpConnection{tlsState: &tls.ConnectionState{Version: version, PeerCertificates: certs}}
+	}
+
+	tests := []struct {
+		name     string
+		policy   tlsPolicy
+		conn     *smtpConnection
+		wantCode int
+	}{
+		{"no_policy_plaintext", tlsPolicy{clientAuth: TLSClientAuthNone}, &smtpConnection{}, 0},
+		{"require_tls_plaintext", tlsPolicy{requireTLS: true, clientAuth: TLSClientAuthNone}, &smtpConnection{}, 530},
+		{"require_tls", tlsPolicy{requireTLS: true, clientAuth: TLSClientAuthNone}, tlsConn(tls.VersionTLS12), 0},
+		{"min_version_plaintext", tlsPolicy{minVersion: tls.VersionTLS13, clientAuth: TLSClientAuthNone}, &smtpConnection{}, 530},
+		{"min_version_too_old", tlsPolicy{minVersion: tls.VersionTLS13, clientAuth: TLSClientAuthNone}, tlsConn(tls.VersionTLS12), 554},
+		{"min_version_met", tlsPolicy{minVersion: tls.VersionTLS13, clientAuth: TLSClientAuthNone}, tlsConn(tls.VersionTLS13), 0},
+		{"request_untrusted", tlsPolicy{clientAuth: TLSClientAuthRequest}, tlsConn(tls.VersionTLS13, untrusted), 0},
+		{"verify_without_cert", tlsPolicy{clientAuth: TLSClientAuthVerify, clientCAs: roots}, tlsConn(tls.VersionTLS13), 0},
+		{"verify_untrusted", tlsPolicy{clientAuth: TLSClientAuthVerify, clientCAs: roots}, tlsConn(tls.VersionTLS13, untrusted), 554},
+		{"verify_trusted", tlsPolicy{clientAuth: TLSClientAuthVerify, clientCAs: roots}, tlsConn(tls.VersionTLS13, trusted), 0},
+		{"require_plaintext", tlsPolicy{clientAuth: TLSClientAuthRequire, clientCAs: roots}, &smtpConnection{}, 530},
+		{"require_without_cert", tlsPolicy{clientAuth: TLSClientAuthRequire, clientCAs: roots}, tlsConn(tls.VersionTLS13), 530},
+		{"require_trusted", tlsPolicy{clientAuth: TLSClientAuthRequire, clientCAs: roots}, tlsConn(tls.VersionTLS13, trusted), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.check(tt.conn)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("check() error = %v, want nil", err)
+				}
+
+				return
+			}
+
+			var smtpErr *gosmtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+				t.Errorf("check() error = %v, want SMTP code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestTLSPolicySession(t *testing.T) {
+	ca := newTestClientCA(t)
+	cfg := testTLSConfig()
+	cfg.SMTPRequireTLS = true
+	cfg.SMTPTLSClientAuth = TLSClientAuthRequire
+	cfg.SMTPTLSClientCAFile = ca.writeFile(t)
+
+	policy, err := newTLSPolicy(cfg)
+	if err != nil {
+		t.Fatalf("newTLSPolicy() error = %v", err)
+	}
+	st, err := newSMTPTLS(cfg, time.Now())
+	if err != nil {
+		t.Fatalf("newSMTPTLS() error = %v", err)
+	}
+	policy.apply(st.config)
+	backend := newSMTPBackend()
+	backend.tls = st
+	backend.tlsPolicy = policy
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serveTestSMTP(t, newSMTPServer(cfg, backend), l)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(st.caPEM)
+	dial := func(startTLS bool, certs ...tls.Certificate) *smtp.Client {
+		c, err := smtp.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		if !startTLS {
+			return c
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: "localhost", RootCAs: roots, Certificates: certs, MinVersion: tls.VersionTLS12}); err != nil {
+			t.Fatalf("StartTLS() error = %v", err)
+		}
+
+		return c
+	}
+	assertMailCode := func(c *smtp.Client, want int) {
+		t.Helper()
+
+		var tpErr *textproto.Error
+		if err := c.Mail("sender@example.com"); !errors.As(err, &tpErr) || tpErr.Code != want {
+			t.Errorf("Mail() error = %v, want code %d", err, want)
+		}
+	}
+
+	assertMailCode(dial(false), 530)
+	assertMailCode(dial(true), 530)
+	assertMailCode(dial(true, newTestClientCA(t).issue(t, "stranger")), 554)
+
+	sendTestMail(t, dial(true, ca.issue(t, "billing-service")), "Mutual TLS")
+
+	msgs := backend.store.List()
+	if len(msgs) != 1 {
+		t.Fatalf("stored %d messages, want 1", len(msgs))
+	}
+	if got, want := msgs[0].view.TLSClientSubject, "CN=billing-service,O=Example"; got != want {
+		t.Errorf("TLSClientSubject = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,225 @@
+'use strict';
+
+// The UI talks to the JSON API mounted under api/ next to this page.
+const api = 'api';
+
+const state = {
+  messages: [],
+  selected: null,
+  tab: 'html',
+  unread: new Set(),
+};
+
+const $ = (id) => document.getElementById(id);
+
+function formatAddresses(list, fallback) {
+  if (list && list.length > 0) {
+    return list.map((a) => (a.Name ? `${a.Name} <${a.Address}>` : a.Address)).join(', ');
+  }
+  return (Array.isArray(fallback) ? fallback.join(', ') : fallback) || '';
+}
+
+function formatTime(value) {
+  const d = new Date(value);
+  return Number.isNaN(d.getTime()) ? '' : d.toLocaleString();
+}
+
+async function fetchJSON(path, options) {
+  const res = await fetch(`${api}${path}`, options);
+  if (!res.ok) {
+    throw new Error(`${res.status} ${res.statusText}`);
+  }
+  return res.json();
+}
+
+// listLimit caps how many messages the list shows; older ones stay reachable through the API.
+const listLimit = 500;
+
+async function loadMessages() {
+  const page = await fetchJSON(`/messages?sort=desc&limit=${listLimit}`);
+  state.messages = page.messages || [];
+  renderList();
+}
+
+function renderList() {
+  const tbody = $('messages');
+  tbody.replaceChildren();
+  $('empty').hidden = state.messages.length > 0;
+
+  for (const msg of state.messages) {
+    const tr = document.createElement('tr');
+    tr.dataset.id = msg.id;
+    tr.classList.toggle('selected', msg.id === state.selected);
+    tr.classList.toggle('new', state.unread.has(msg.id));
+
+    for (const text of [
+      formatAddresses(msg.from, msg.smtpFrom),
+      formatAddresses(msg.to, msg.smtpTo),
+      msg.subject || '(no subject)',
+      formatTime(msg.receivedTime),
+    ]) {
+      const td = document.createElement('td');
+      td.textContent = text;
+      td.title = text;
+      tr.appendChild(td);
+    }
+
+    tr.addEventListener('click', () => selectMessage(msg.id));
+    tbody.appendChild(tr);
+  }
+}
+
+async function selectMessage(id) {
+  state.selected = id;
+  state.unread.delete(id);
+  renderList();
+
+  const msg = await fetchJSON(`/messages/${encodeURIComponent(id)}`);
+  $('detail').hidden = false;
+  $('detail-subject').textContent = msg.subject || '(no subject)';
+  $('detail-from').textContent = formatAddresses(msg.from, msg.smtpFrom);
+  $('detail-to').textContent = formatAddresses(msg.to, msg.smtpTo);
+  $('detail-received').textContent = formatTime(msg.receivedTime);
+  $('detail-connection').textContent = `#${msg.connectionId}${msg.listener ? ` ${msg.listener}` : ''} ${msg.clientHost || ''} (${msg.clientAddr})${msg.tlsUsed ? ` ${msg.tlsVersion || 'TLS'}` : ''}${msg.authUser ? ` AUTH ${msg.authUser}` : ''}`;
+  $('detail-download').href = `${api}/messages/${encodeURIComponent(id)}/raw`;
+
+  renderHTML(msg);
+  $('pane-text').textContent = msg.text || '';
+  renderHeaders(msg.headers || []);
+  renderAttachments(msg);
+  $('pane-raw').textContent = '';
+  if (state.tab === 'raw') {
+    loadRaw(id);
+  }
+}
+
+function renderHTML(msg) {
+  let html = msg.html || '';
+  // Point cid: references at the attachment download endpoint.
+  for (const a of msg.attachments || []) {
+    if (a.contentId) {
+      const url = `${api}/messages/${encodeURIComponent(msg.id)}/attachments/${a.index}`;
+      html = html.split(`cid:${a.contentId}`).join(new URL(url, document.baseURI).href);
+    }
+  }
+  // srcdoc inside a sandbox without allow-scripts keeps mail content inert.
+  $('pane-html').srcdoc = html || '<p style="font-family: sans-serif; color: #666">This message has no HTML part.</p>';
+}
+
+function renderHeaders(headers) {
+  const tbody = $('pane-headers').querySelector('tbody');
+  tbody.replaceChildren();
+  for (const h of headers) {
+    const tr = document.createElement('tr');
+    const key = document.createElement('td');
+    const value = document.createElement('td');
+    key.textContent = h.key;
+    value.textContent = h.value;
+    // Show the encoded form on hover when decoding or unfolding changed it.
+    if (h.raw && h.raw !== h.value) value.title = h.raw;
+    tr.append(key, value);
+    tbody.appendChild(tr);
+  }
+}
+
+function renderAttachments(msg) {
+  const ul = $('pane-attachments');
+  ul.replaceChildren();
+  const attachments = msg.attachments || [];
+  if (attachments.length === 0) {
+    const li = document.createElement('li');
+    li.textContent = 'No attachments.';
+    ul.appendChild(li);
+    return;
+  }
+  for (const a of attachments) {
+    const li = document.createElement('li');
+    const link = document.createElement('a');
+    link.href = `${api}/messages/${encodeURIComponent(msg.id)}/attachments/${a.index}`;
+    link.textContent = a.filename || `part-${a.index}`;
+    link.download = a.filename || `part-${a.index}`;
+    li.append(link, ` ${a.contentType}, ${a.size} bytes${a.disposition ? `, ${a.disposition}` : ''}`);
+    ul.appendChild(li);
+  }
+}
+
+async function loadRaw(id) {
+  const res = await fetch(`${api}/messages/${encodeURIComponent(id)}/raw`);
+  $('pane-raw').textContent = res.ok ? await res.text() : `${res.status} ${res.statusText}`;
+}
+
+function selectTab(tab) {
+  state.tab = tab;
+  for (const button of document.querySelectorAll('.tabs button')) {
+    button.classList.toggle('active', button.dataset.tab === tab);
+  }
+  for (const pane of document.querySelectorAll('.pane')) {
+    pane.hidden = pane.id !== `pane-${tab}`;
+  }
+  if (tab === 'raw' && state.selected && !$('pane-raw').textContent) {
+    loadRaw(state.selected);
+  }
+}
+
+function clearDetail() {
+  state.selected = null;
+  $('detail').hidden = true;
+}
+
+async function deleteSelected() {
+  if (state.selected) {
+    await fetch(`${api}/messages/${encodeURIComponent(state.selected)}`, { method: 'DELETE' });
+  }
+}
+
+async function deleteAll() {
+  if (window.confirm('Delete all captured messages?')) {
+    await fetch(`${api}/messages`, { method: 'DELETE' });
+  }
+}
+
+function subscribe() {
+  const events = new EventSource(`${api}/events`);
+  events.addEventListener('open', () => {
+    $('status').textContent = 'live';
+    // Catch up on anything missed while disconnected.
+    loadMessages();
+  });
+  events.addEventListener('error', () => {
+    $('status').textContent = 'reconnecting…';
+  });
+  events.addEventListener('message.received', (e) => {
+    const summary = JSON.parse(e.data);
+    state.unread.add(summary.id);
+    state.messages.unshift(summary);
+    state.messages.length = Math.min(state.messages.length, listLimit);
+    renderList();
+  });
+  events.addEventListener('message.deleted', (e) => {
+    const { id } = JSON.parse(e.data);
+    state.messages = state.messages.filter((m) => m.id !== id);
+    if (state.selected === id) {
+      clearDetail();
+    }
+    renderList();
+  });
+  events.addEventListener('mailbox.cleared', () => {
+    state.messages = [];
+    clearDetail();
+    renderList();
+  });
+  events.addEventListener('mailbox.restored', () => {
+    state.unread.clear();
+    clearDetail();
+    loadMessages();
+  });
+}
+
+for (const button of document.querySelectorAll('.tabs button')) {
+  button.addEventListener('click', () => selectTab(button.dataset.tab));
+}
+$('refresh').addEventListener('click', loadMessages);
+$('clear').addEventListener('click', deleteAll);
+$('detail-delete').addEventListener('click', deleteSelected);
+
+subscribe();
@@ -21,6 +21,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	store, err := fakesmtpserver.OpenStore(cfg)
+	if err != nil {
+		slog.Error("Failed to open message store", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			slog.Error("Failed to close message store", "error", err)
+		}
+	}()
+
+	if err := fakesmtpserver.OpenMaildir(cfg); err != nil {
+		slog.Error("Failed to open maildir", "error", err)
+		os.Exit(1) //nolint:gocritic // nothing to close that outlives the process
+	}
+
+	if err := fakesmtpserver.ConfigureTLS(cfg); err != nil {
+		slog.Error("Failed to configure TLS", "error", err)
+		os.Exit(1)
+	}
+
+	if err := fakesmtpserver.ConfigureAuth(cfg); err != nil {
+		slog.Error("Failed to configure AUTH", "error", err)
+		os.Exit(1)
+	}
+
 	ctx := context.Background()
 	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
@@ -28,6 +54,16 @@ func main() {
 
 		return nil
 	})
+	if cfg.SMTPSAddr != "" {
+		eg.Go(func() error {
+			slog.Info("smtps server", "error", fakesmtpserver.StartSMTPSServer(cfg))
+
+			return nil
+		})
+	}
+	eg.Go(func() error {
+		return fakesmtpserver.StartRetentionSweeper(ctx, cfg)
+	})
 	eg.Go(func() error {
 		slog.Info("view server", "error", fakesmtpserver.StartViewServer(cfg))
 